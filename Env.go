@@ -0,0 +1,174 @@
+package vutils
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const RedactedValue = "[REDACTED]"
+
+var defaultSecretKeyPattern = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSWORD|PASSWD|PASSPHRASE|API_?KEY|PRIVATE_?KEY|CREDENTIAL|AUTH)`)
+
+// Env builds the environment of a command. Later values for a key replace earlier ones and Environ returns the
+// variables sorted by key so the result is the same no matter the order they were added in.
+//
+// Allow, Deny and Secret take key patterns where * matches any run of characters, e.g. AWS_*.
+type Env struct {
+	values  map[string]string
+	unset   map[string]bool
+	allow   []string
+	deny    []string
+	secrets []string
+}
+
+func NewEnv() *Env {
+	return &Env{
+		values: map[string]string{},
+		unset:  map[string]bool{},
+	}
+}
+
+// InheritEnv starts from the environment of this process.
+func InheritEnv() *Env {
+	return EnvFromSlice(os.Environ())
+}
+
+// EnvFromSlice parses KEY=value entries, entries without a key are dropped.
+func EnvFromSlice(env []string) *Env {
+	e := NewEnv()
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			e.values[kv[:i]] = kv[i+1:]
+		}
+	}
+	return e
+}
+
+func (e *Env) Set(key string, value string) *Env {
+	e.values[key] = value
+	delete(e.unset, key)
+	return e
+}
+
+func (e *Env) SetMap(values map[string]string) *Env {
+	for key, value := range values {
+		e.Set(key, value)
+	}
+	return e
+}
+
+// Merge applies the values and unset keys of other on top of this Env.
+func (e *Env) Merge(other *Env) *Env {
+	for key, value := range other.values {
+		e.Set(key, value)
+	}
+	for key := range other.unset {
+		e.Unset(key)
+	}
+	return e
+}
+
+func (e *Env) Unset(keys ...string) *Env {
+	for _, key := range keys {
+		delete(e.values, key)
+		e.unset[key] = true
+	}
+	return e
+}
+
+func (e *Env) Get(key string) (string, bool) {
+	if !e.permitted(key) {
+		return "", false
+	}
+	value, ok := e.values[key]
+	return value, ok
+}
+
+// Allow restricts the environment to keys matching at least one of the patterns.
+func (e *Env) Allow(patterns ...string) *Env {
+	e.allow = append(e.allow, patterns...)
+	return e
+}
+
+// Deny drops keys matching any of the patterns, it wins over Allow.
+func (e *Env) Deny(patterns ...string) *Env {
+	e.deny = append(e.deny, patterns...)
+	return e
+}
+
+// Secret marks keys whose values are redacted wherever the environment is logged, keys that look like they hold
+// passwords, tokens or keys are always treated as secret.
+func (e *Env) Secret(patterns ...string) *Env {
+	e.secrets = append(e.secrets, patterns...)
+	return e
+}
+
+func (e *Env) IsSecret(key string) bool {
+	return defaultSecretKeyPattern.MatchString(key) || envKeyMatches(key, e.secrets)
+}
+
+func (e *Env) permitted(key string) bool {
+	if len(e.allow) > 0 && !envKeyMatches(key, e.allow) {
+		return false
+	}
+	return !envKeyMatches(key, e.deny)
+}
+
+func (e *Env) Keys() []string {
+	keys := []string{}
+	for key := range e.values {
+		if e.permitted(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Environ returns the final KEY=value list for exec.Cmd.Env.
+func (e *Env) Environ() []string {
+	env := []string{}
+	for _, key := range e.Keys() {
+		env = append(env, key+"="+e.values[key])
+	}
+	return env
+}
+
+// Redacted returns Environ with the values of secret keys replaced by RedactedValue.
+func (e *Env) Redacted() []string {
+	env := []string{}
+	for _, key := range e.Keys() {
+		env = append(env, key+"="+e.RedactValue(key, e.values[key]))
+	}
+	return env
+}
+
+func (e *Env) RedactValue(key string, value string) string {
+	if e.IsSecret(key) {
+		return RedactedValue
+	}
+	return value
+}
+
+func envKeyMatches(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// envSet drops every existing entry for key from a KEY=value list and appends the new one
+func envSet(env []string, key string, value string) []string {
+	set := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			set = append(set, kv)
+		}
+	}
+	return append(set, key+"="+value)
+}
@@ -0,0 +1,113 @@
+// +build !js
+
+package vutils
+
+import (
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		env      *Env
+		environ  []string
+		redacted []string
+	}{
+		{
+			name:    "sorted by key",
+			env:     NewEnv().Set("B", "2").Set("A", "1").SetMap(map[string]string{"C": "3"}),
+			environ: []string{"A=1", "B=2", "C=3"},
+		},
+		{
+			name:    "later values win",
+			env:     NewEnv().Set("A", "1").Set("A", "2"),
+			environ: []string{"A=2"},
+		},
+		{
+			name:    "from slice",
+			env:     EnvFromSlice([]string{"A=x=y", "=nokey", "NOVALUE", "EMPTY="}),
+			environ: []string{"A=x=y", "EMPTY="},
+		},
+		{
+			name:    "unset then set",
+			env:     NewEnv().Set("A", "1").Set("B", "2").Unset("A", "B").Set("B", "3"),
+			environ: []string{"B=3"},
+		},
+		{
+			name:    "merge",
+			env:     NewEnv().Set("A", "1").Set("B", "2").Merge(NewEnv().Set("C", "3").Set("B", "4").Unset("A")),
+			environ: []string{"B=4", "C=3"},
+		},
+		{
+			name:    "allow",
+			env:     NewEnv().Set("AWS_REGION", "eu").Set("AWS_PROFILE", "dev").Set("HOME", "/root").Allow("AWS_*"),
+			environ: []string{"AWS_PROFILE=dev", "AWS_REGION=eu"},
+		},
+		{
+			name:    "deny wins over allow",
+			env:     NewEnv().Set("AWS_REGION", "eu").Set("AWS_PROFILE", "dev").Allow("AWS_*").Deny("*_PROFILE"),
+			environ: []string{"AWS_REGION=eu"},
+		},
+		{
+			name:     "secrets",
+			env:      NewEnv().Set("GITHUB_TOKEN", "t").Set("DB_PASSWORD", "p").Set("SIGNING", "s").Set("USER", "u").Secret("SIGN*"),
+			environ:  []string{"DB_PASSWORD=p", "GITHUB_TOKEN=t", "SIGNING=s", "USER=u"},
+			redacted: []string{"DB_PASSWORD=" + RedactedValue, "GITHUB_TOKEN=" + RedactedValue, "SIGNING=" + RedactedValue, "USER=u"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		if got := tt.env.Environ(); !equalStrings(got, tt.environ) {
+			t.Errorf("%s: Environ = %q, want %q", tt.name, got, tt.environ)
+		}
+
+		if tt.redacted == nil {
+			tt.redacted = tt.environ
+		}
+
+		if got := tt.env.Redacted(); !equalStrings(got, tt.redacted) {
+			t.Errorf("%s: Redacted = %q, want %q", tt.name, got, tt.redacted)
+		}
+
+	}
+
+}
+
+func TestEnvGet(t *testing.T) {
+
+	env := NewEnv().Set("A", "1").Set("B", "").Deny("C").Set("C", "3")
+
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"A", "1", true},
+		{"B", "", true},
+		{"C", "", false},
+		{"D", "", false},
+	}
+
+	for _, tt := range tests {
+		if value, ok := env.Get(tt.key); value != tt.value || ok != tt.ok {
+			t.Errorf("Get(%s) = %q, %t, want %q, %t", tt.key, value, ok, tt.value, tt.ok)
+		}
+	}
+
+}
+
+func TestEnvSet(t *testing.T) {
+
+	got := envSet([]string{"A=1", "AB=2", "A=3", "B=4"}, "A", "5")
+
+	if want := []string{"AB=2", "B=4", "A=5"}; !equalStrings(got, want) {
+		t.Errorf("envSet = %q, want %q", got, want)
+	}
+
+	if got := envSet(nil, "A", ""); !equalStrings(got, []string{"A="}) {
+		t.Errorf("envSet on an empty list = %q", got)
+	}
+
+}
@@ -4,80 +4,247 @@ package vutils
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
-	"os/signal"
-	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type execUtils struct {
+	lock     sync.RWMutex
+	dryRun   bool
+	plan     *ExecPlan
+	executor Executor
+	audit    *AuditLog
 }
 
 type ExecAsyncCommand struct {
-	path           string
-	args           []string
-	env            []string
-	Proc           *exec.Cmd
-	errOnly        bool
-	dir            string
-	reader         io.ReadCloser
-	error          io.ReadCloser
-	writer         io.WriteCloser
-	stdoutBuffer   bytes.Buffer
-	stdoutWriter   *bufio.Writer
-	stderrWriter   *bufio.Writer
-	stderrBuffer   bytes.Buffer
-	intChan        chan os.Signal
-	intBound       bool
-	stdioBound     bool
-	stdioCapture   bool
-	stdinBound     bool
-	combineCapture bool
-	useSudo        bool
+	path            string
+	args            []string
+	env             []string
+	Proc            *exec.Cmd
+	errOnly         bool
+	dir             string
+	reader          io.ReadCloser
+	error           io.ReadCloser
+	writer          io.WriteCloser
+	stdoutWriter    *CaptureBuffer
+	stderrWriter    *CaptureBuffer
+	stdoutOptions   *CaptureOptions
+	stderrOptions   *CaptureOptions
+	signalForwards  []signalForward
+	windowSize      bool
+	forwarder       *signalForwarder
+	stdioBound      bool
+	stdioCapture    bool
+	stdinBound      bool
+	stdin           *stdinSource
+	stdinLock       sync.Mutex
+	stdinDone       chan struct{}
+	stdinErr        error
+	combineCapture  bool
+	privilege       PrivilegeBackend
+	privilegeErr    error
+	ioWait          sync.WaitGroup
+	dryRun          bool
+	stdinChild      io.Closer
+	stdoutChild     io.Closer
+	stderrChild     io.Closer
+	executor        Executor
+	proc            ExecProcess
+	procLock        sync.Mutex
+	outputAttachers []func()
+	stderrTail      *tailBuffer
+	stdoutDigest    *outputDigest
+	stderrDigest    *outputDigest
+	retry           *RetryPolicy
+	attempts        []*RetryAttempt
+	attemptStart    time.Time
+	resources       *ResourceLimits
+	namespaces      *NamespaceOptions
+	envBuilder      *Env
+	pty             bool
+	ptyMaster       *os.File
+	ptyErr          error
+	captureErr      error
 }
 
 func (ec *ExecAsyncCommand) init() *ExecAsyncCommand {
 	if ec.env == nil {
 		ec.env = []string{}
 	}
-	if ec.useSudo {
-		cargs := append([]string{"-n", ec.path}, ec.args...)
-		ec.Proc = exec.Command("sudo", cargs...)
-	} else {
-		ec.Proc = exec.Command(ec.path, ec.args...)
+	path, args := ec.path, ec.args
+	var cred *PrivilegeCredential
+	//a remote executor elevates the command on its host
+	if ec.privilege != nil && !ec.runsRemotely() {
+		pc := &PrivilegedCommand{
+			Path: ec.path,
+			Args: append([]string{}, ec.args...),
+		}
+		if err := ec.privilege.Elevate(pc); err != nil {
+			ec.privilegeErr = err
+		} else {
+			path, args, cred = pc.Path, pc.Args, pc.Credential
+		}
+	}
+	ec.Proc = exec.Command(path, args...)
+	if cred != nil {
+		if err := applyPrivilegeCredential(ec.Proc, cred); err != nil {
+			ec.privilegeErr = err
+		}
+	}
+	ec.closeStdio()
+	ec.stderrTail = newTailBuffer(stderrTailSize)
+	ec.stdoutDigest, ec.stderrDigest = nil, nil
+	if Exec.AuditLog() != nil {
+		ec.stdoutDigest, ec.stderrDigest = newOutputDigest(), newOutputDigest()
 	}
 	if !ec.errOnly {
-		ec.reader, _ = ec.Proc.StdoutPipe()
+		if r, w, err := os.Pipe(); err == nil {
+			ec.reader, ec.stdoutChild = r, w
+			ec.Proc.Stdout = w
+		}
 	}
 
-	ec.error, _ = ec.Proc.StderrPipe()
-	ec.writer, _ = ec.Proc.StdinPipe()
+	if r, w, err := os.Pipe(); err == nil {
+		ec.error, ec.stderrChild = r, w
+		ec.Proc.Stderr = w
+	}
+	if r, w, err := os.Pipe(); err == nil {
+		ec.writer, ec.stdinChild = w, r
+		ec.Proc.Stdin = r
+	}
+	if ec.pty {
+		ec.attachPTY()
+	}
 
 	return ec
 }
 
+func (ec *ExecAsyncCommand) childEnds() []io.Closer {
+	ends := []io.Closer{}
+	for _, c := range []io.Closer{ec.stdinChild, ec.stdoutChild, ec.stderrChild} {
+		if c != nil {
+			ends = append(ends, c)
+		}
+	}
+	return ends
+}
+
+func (ec *ExecAsyncCommand) closeChildEnds() {
+	for _, c := range ec.childEnds() {
+		c.Close()
+	}
+}
+
+// redirectStdin replaces the STDIN pipe with r, owned is closed on our side once the command has been started
+func (ec *ExecAsyncCommand) redirectStdin(r io.Reader, owned io.Closer) {
+	if ec.writer != nil {
+		ec.writer.Close()
+		ec.writer = nil
+	}
+	if ec.stdinChild != nil {
+		ec.stdinChild.Close()
+	}
+	ec.stdinChild = owned
+	ec.Proc.Stdin = r
+}
+
+func (ec *ExecAsyncCommand) redirectStdout(w io.Writer, owned io.Closer) {
+	if ec.reader != nil {
+		ec.reader.Close()
+		ec.reader = nil
+	}
+	if ec.stdoutChild != nil {
+		ec.stdoutChild.Close()
+	}
+	ec.stdoutChild = owned
+	ec.Proc.Stdout = w
+}
+
+func (ec *ExecAsyncCommand) redirectStderr(w io.Writer, owned io.Closer) {
+	if ec.error != nil {
+		ec.error.Close()
+		ec.error = nil
+	}
+	if ec.stderrChild != nil {
+		ec.stderrChild.Close()
+	}
+	ec.stderrChild = owned
+	ec.Proc.Stderr = w
+}
+
+// abandonStdio releases the pipes of a command that failed to start, waiting for anything reading them to finish first
+func (ec *ExecAsyncCommand) abandonStdio() {
+	ec.closeChildEnds()
+	ec.ioWait.Wait()
+	ec.closeStdio()
+	ec.finishCapture()
+}
+
+// attachOutput runs fn now and again each time the command is re-initialised for a retry, fn starts the goroutines
+// (registered with ioWait) consuming the current pipes
+func (ec *ExecAsyncCommand) attachOutput(fn func()) {
+	ec.outputAttachers = append(ec.outputAttachers, fn)
+	fn()
+}
+
+// stdoutSource is what output goroutines should read STDOUT from so it is hashed for the audit log
+func (ec *ExecAsyncCommand) stdoutSource() io.Reader {
+	if ec.stdoutDigest == nil {
+		return ec.reader
+	}
+	ec.stdoutDigest.read = true
+	return io.TeeReader(ec.reader, ec.stdoutDigest)
+}
+
+// stderrSource is what output goroutines should read STDERR from so the tail of it is kept
+func (ec *ExecAsyncCommand) stderrSource() io.Reader {
+	if ec.stderrDigest == nil {
+		return io.TeeReader(ec.error, ec.stderrTail)
+	}
+	ec.stderrDigest.read = true
+	return io.TeeReader(ec.error, io.MultiWriter(ec.stderrTail, ec.stderrDigest))
+}
+
+func (ec *ExecAsyncCommand) closeStdio() {
+	ec.closeChildEnds()
+	for _, c := range []io.Closer{ec.reader, ec.error, ec.writer} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
 func (ec *ExecAsyncCommand) BindToStdoutAndStdErr() *ExecAsyncCommand {
 	if ec.stdioBound {
 		return ec
 	} else if ec.stdioCapture {
-		log.Println("Unable to Bind STDIO as STDIO is already being captured.")
+		logWarn("Unable to Bind STDIO as STDIO is already being captured.", LogF("command", ec.path))
 		return ec
 	}
-	if !ec.errOnly {
+	ec.attachOutput(func() {
+		if !ec.errOnly {
+			reader := ec.stdoutSource()
+			ec.ioWait.Add(1)
+			go func() {
+				defer ec.ioWait.Done()
+				io.Copy(os.Stdout, reader)
+			}()
+		}
+
+		errReader := ec.stderrSource()
+		ec.ioWait.Add(1)
 		go func() {
-			io.Copy(os.Stdout, ec.reader)
+			defer ec.ioWait.Done()
+			io.Copy(os.Stderr, errReader)
 		}()
-	}
-
-	go func() {
-		io.Copy(os.Stderr, ec.error)
-	}()
+	})
 	ec.stdioBound = true
 	return ec
 }
@@ -86,21 +253,19 @@ func (ec *ExecAsyncCommand) GetPipes() (io.ReadCloser, io.ReadCloser, io.WriteCl
 	return ec.reader, ec.error, ec.writer
 }
 
+// BindToStdin feeds the STDIN of this process to the command, see SetStdin for other sources.
 func (ec *ExecAsyncCommand) BindToStdin() *ExecAsyncCommand {
 	if ec.stdinBound {
 		return ec
 	}
-
-	go func() {
-		io.Copy(ec.writer, os.Stdin)
-	}()
-	ec.stdinBound = true
-	return ec
+	return ec.SetStdin(os.Stdin)
 }
 
 func (ec *ExecAsyncCommand) Write(data []byte) error {
 	if ec.stdinBound {
-		return nil
+		return errors.New("Unable to write to a command whose STDIN is bound to a source")
+	} else if ec.writer == nil {
+		return errors.New("Unable to write to a command whose STDIN has been redirected")
 	}
 
 	_, err := ec.writer.Write(data)
@@ -111,73 +276,88 @@ func (ec *ExecAsyncCommand) CaptureStdoutAndStdErr(combine bool, outputToStdIO b
 	if ec.stdioCapture {
 		return ec
 	} else if ec.stdioBound {
-		log.Println("Unable to Capture STDIO as STDIO is already bound.")
+		logWarn("Unable to Capture STDIO as STDIO is already bound.", LogF("command", ec.path))
 		return ec
 	}
-	if !ec.errOnly {
-		go func() {
-			if outputToStdIO {
-				//outScanner := bufio.NewScanner(ec.reader)
-				ec.stdoutWriter = bufio.NewWriter(&ec.stdoutBuffer)
+	ec.attachOutput(func() {
+		if ec.stdoutWriter == nil {
+			ec.stdoutWriter = NewCaptureBuffer(ec.stdoutOptions)
+			ec.stderrWriter = NewCaptureBuffer(ec.stderrOptions)
+		} else {
+			ec.stdoutWriter.reset()
+			ec.stderrWriter.reset()
+		}
+		reader, errReader := ec.stdoutSource(), ec.stderrSource()
+		stdoutWriter, stderrWriter := ec.stdoutWriter, ec.stderrWriter
+		if !ec.errOnly {
+			ec.ioWait.Add(1)
+			go func() {
+				defer ec.ioWait.Done()
+				if outputToStdIO {
+					//outScanner := bufio.NewScanner(ec.reader)
+
+					tee := io.TeeReader(reader, os.Stdout)
+					//_ = io.TeeReader(tee, os.Stdout)
+					io.Copy(stdoutWriter, tee)
+
+					//if combine {
+					//	ec.stdoutWriter = stdoutWriter
+					//}
+					//for outScanner.Scan() {
+					//	txt := outScanner.Text()
+					//	println(txt)
+					//	ec.stdoutWriter.WriteString(txt + "\n")
+					//}
+				} else {
+					io.Copy(stdoutWriter, reader)
+				}
+			}()
+		}
 
-				tee := io.TeeReader(ec.reader, os.Stdout)
-				//_ = io.TeeReader(tee, os.Stdout)
-				io.Copy(ec.stdoutWriter, tee)
+		ec.ioWait.Add(1)
+		go func() {
+			defer ec.ioWait.Done()
+			if outputToStdIO || (combine && !ec.errOnly) {
+				//outScanner := bufio.NewScanner(ec.error)
+
+				tee := io.TeeReader(errReader, os.Stderr)
+				//_ = io.TeeReader(tee, os.Stderr)
+				if combine && !ec.errOnly {
+					outTee := io.TeeReader(tee, stdoutWriter)
+					io.Copy(stderrWriter, outTee)
+				} else {
+					io.Copy(stderrWriter, tee)
+				}
 
-				//if combine {
-				//	ec.stdoutWriter = stdoutWriter
-				//}
 				//for outScanner.Scan() {
 				//	txt := outScanner.Text()
 				//	println(txt)
-				//	ec.stdoutWriter.WriteString(txt + "\n")
+				//	ec.stderrWriter.WriteString(txt + "\n")
+				//	if combine && !ec.errOnly {
+				//		ec.stdoutWriter.WriteString(txt + "\n")
+				//	}
 				//}
 			} else {
-				ec.stdoutWriter = bufio.NewWriter(&ec.stdoutBuffer)
-				io.Copy(ec.stdoutWriter, ec.reader)
+				io.Copy(stderrWriter, errReader)
 			}
 		}()
-	}
-
-	go func() {
-		if outputToStdIO || (combine && !ec.errOnly) {
-			//outScanner := bufio.NewScanner(ec.error)
-			ec.stderrWriter = bufio.NewWriter(&ec.stderrBuffer)
-
-			tee := io.TeeReader(ec.error, os.Stderr)
-			//_ = io.TeeReader(tee, os.Stderr)
-			if combine && !ec.errOnly {
-				outTee := io.TeeReader(tee, ec.stdoutWriter)
-				io.Copy(ec.stderrWriter, outTee)
-			} else {
-				io.Copy(ec.stderrWriter, tee)
-			}
-
-			//for outScanner.Scan() {
-			//	txt := outScanner.Text()
-			//	println(txt)
-			//	ec.stderrWriter.WriteString(txt + "\n")
-			//	if combine && !ec.errOnly {
-			//		ec.stdoutWriter.WriteString(txt + "\n")
-			//	}
-			//}
-		} else {
-			ec.stderrWriter = bufio.NewWriter(&ec.stderrBuffer)
-			io.Copy(ec.stderrWriter, ec.error)
-		}
-	}()
+	})
 	ec.stdioCapture = true
 	return ec
 }
 
 func (ec *ExecAsyncCommand) GetStdoutBuffer() []byte {
-	ec.stdoutWriter.Flush()
-	return ec.stdoutBuffer.Bytes()
+	if ec.stdoutWriter == nil {
+		return nil
+	}
+	return ec.stdoutWriter.Bytes()
 }
 
 func (ec *ExecAsyncCommand) GetStderrBuffer() []byte {
-	ec.stderrWriter.Flush()
-	return ec.stderrBuffer.Bytes()
+	if ec.stderrWriter == nil {
+		return nil
+	}
+	return ec.stderrWriter.Bytes()
 }
 
 func (ec *ExecAsyncCommand) SetWorkingDir(path string) *ExecAsyncCommand {
@@ -190,8 +370,9 @@ func (ec *ExecAsyncCommand) CopyEnv() *ExecAsyncCommand {
 	return ec
 }
 
+// AddEnv sets a variable, replacing any value the key already has.
 func (ec *ExecAsyncCommand) AddEnv(key string, value string) *ExecAsyncCommand {
-	ec.env = append(ec.env, fmt.Sprintf(`%s=%s`, key, value))
+	ec.env = envSet(ec.env, key, value)
 	return ec
 }
 
@@ -200,27 +381,116 @@ func (ec *ExecAsyncCommand) SetEnv(env []string) *ExecAsyncCommand {
 	return ec
 }
 
+// UseEnv gives the command exactly the environment built by env (even when it is empty), its secret keys are
+// redacted wherever the command is logged or recorded.
+func (ec *ExecAsyncCommand) UseEnv(env *Env) *ExecAsyncCommand {
+	ec.env = env.Environ()
+	ec.envBuilder = env
+	return ec
+}
+
+// redactEnvValue hides the value of secret keys, going by the Env given to UseEnv or the default secret key patterns
+func (ec *ExecAsyncCommand) redactEnvValue(key string, value string) string {
+	if ec.envBuilder != nil {
+		return ec.envBuilder.RedactValue(key, value)
+	}
+	return NewEnv().RedactValue(key, value)
+}
+
 func (ec *ExecAsyncCommand) Sudo() *ExecAsyncCommand {
-	if !ec.useSudo {
-		if Exec.CheckSudo() {
+	if ec.privilege == nil {
+		//being root here says nothing about the user a remote executor runs the command as
+		if !ec.runsRemotely() && Exec.CheckSudo() {
 			return ec
 		}
-		ec.useSudo = true
-		return ec.init()
+		if err := ec.Escalate(&SudoBackend{}); err != nil {
+			logWarn("Unable to escalate command using sudo", LogF("command", ec.path), LogF("error", err))
+		}
 	}
 
 	return ec
 }
 
-func (ec *ExecAsyncCommand) Start() error {
-	//set what needs to be set..
+func (ec *ExecAsyncCommand) Escalate(backend PrivilegeBackend) error {
+	if ec.process() != nil {
+		return errors.New("Unable to escalate the privileges of a command that has already been started")
+	} else if backend == nil {
+		return errors.New("No privilege backend supplied")
+	}
 
-	if ec.env != nil && len(ec.env) > 0 {
+	//check up front so we fail here rather than with a confusing error from the command later on, a remote executor
+	//checks on its host when the command starts and nothing is checked in dry-run mode
+	if !ec.runsRemotely() && !Exec.IsDryRun() {
+		if err := backend.Available(); err != nil {
+			ec.privilegeErr = err
+			return err
+		}
+	}
+
+	ec.privilege = backend
+	ec.privilegeErr = nil
+	ec.init()
+
+	return ec.privilegeErr
+}
+
+func (ec *ExecAsyncCommand) startProc() error {
+	ec.attemptStart = time.Now()
+	if ec.privilegeErr != nil {
+		ec.abandonStdio()
+		return ec.privilegeErr
+	} else if ec.ptyErr != nil {
+		ec.abandonStdio()
+		return ec.ptyErr
+	} else if ec.captureErr != nil {
+		ec.abandonStdio()
+		return ec.captureErr
+	}
+	if Exec.IsDryRun() {
+		ec.recordDryRun()
+		return nil
+	}
+	if err := ec.validatePrivilege(); err != nil {
+		ec.abandonStdio()
+		ec.audit(err)
+		return err
+	}
+	proc, err := ec.getExecutor().Start(ec.commandSpec())
+	if err != nil {
+		ec.abandonStdio()
+		ec.audit(err)
+		return err
+	}
+	ec.setProcess(proc)
+	ec.startForwarding()
+	ec.startStdin()
+	return nil
+}
+
+// validatePrivilege lets the privilege backend check it can escalate right before the command starts
+func (ec *ExecAsyncCommand) validatePrivilege() error {
+	if validator, ok := ec.privilege.(privilegeValidator); ok && !ec.runsRemotely() {
+		return validator.validate()
+	}
+	return nil
+}
+
+// prepareProc applies the environment and working directory to Proc before it is started
+func (ec *ExecAsyncCommand) prepareProc() {
+	if ec.envBuilder != nil {
+		ec.Proc.Env = append([]string{}, ec.env...)
+	} else if ec.env != nil && len(ec.env) > 0 {
 		ec.Proc.Env = ec.env
 	}
 	if ec.dir != "" {
 		ec.Proc.Dir = ec.dir
 	}
+}
+
+func (ec *ExecAsyncCommand) Start() error {
+	//set what needs to be set..
+
+	ec.prepareProc()
 
 	//if !ec.errOnly {
 	//	defer ec.reader.Close()
@@ -230,20 +500,21 @@ func (ec *ExecAsyncCommand) Start() error {
 	//}
 	//defer ec.writer.Close()
 	//defer ec.error.Close()
-	fmt.Printf("$: %s %s\n", ec.Proc.Path, strings.Join(ec.Proc.Args, ` `))
-	if err := ec.Proc.Start(); err != nil {
+	logInfo("Starting command", LogF("command", ec.Proc.Path), LogF("args", strings.Join(ec.Proc.Args, ` `)))
+	if err := ec.startProc(); err != nil {
 		return err
 	}
+	logDebug("Started command", LogF("command", ec.Proc.Path), LogF("pid", ec.Pid()))
 	return nil
 }
 
 func (ec *ExecAsyncCommand) StartAndWait() error {
-	if ec.env != nil && len(ec.env) > 0 {
-		ec.Proc.Env = ec.env
-	}
-	if ec.dir != "" {
-		ec.Proc.Dir = ec.dir
-	}
+	return ec.startAndWait(nil)
+}
+
+// startAndWait calls started once the process is running, before waiting for it
+func (ec *ExecAsyncCommand) startAndWait(started func()) error {
+	ec.prepareProc()
 
 	//if ec.stdioCapture && ec.stderrWriter != nil {
 	//	if !ec.errOnly && ec.stdoutWriter != nil {
@@ -252,42 +523,100 @@ func (ec *ExecAsyncCommand) StartAndWait() error {
 	//	defer ec.stderrWriter.Flush()
 	//}
 
-	fmt.Printf("$: %s %s - IN: %s\n", ec.Proc.Path, strings.Join(ec.Proc.Args, ` `), ec.Proc.Dir)
-	if err := ec.Proc.Start(); err != nil {
-		return err
-	} else if err := ec.Wait(); err != nil {
+	logInfo("Starting command", LogF("command", ec.Proc.Path), LogF("args", strings.Join(ec.Proc.Args, ` `)), LogF("dir", ec.Proc.Dir))
+	err := ec.startProc()
+	if err != nil && ec.retry == nil {
 		return err
+	} else if err == nil {
+		logDebug("Started command", LogF("command", ec.Proc.Path), LogF("pid", ec.Pid()))
+		if started != nil {
+			started()
+		}
 	}
-	return nil
+	//a failed start is handed to wait so a retry policy can decide whether to try again
+	return ec.wait(err)
 }
 
 func (ec *ExecAsyncCommand) Wait() error {
+	return ec.wait(nil)
+}
 
-	if !ec.errOnly {
-		defer ec.reader.Close()
+func (ec *ExecAsyncCommand) wait(startErr error) error {
+
+	defer ec.stopForwarding()
+	defer ec.closeStdio()
+	defer ec.finishCapture()
+
+	err := startErr
+
+	if err == nil {
+		err = ec.waitProc()
 	}
-	if ec.intChan != nil && ec.intBound {
-		defer close(ec.intChan)
+
+	err = ec.retryLoop(err)
+
+	if stdinErr := ec.stdinResult(); err == nil {
+		err = stdinErr
 	}
-	defer ec.writer.Close()
-	defer ec.error.Close()
 
-	if err := ec.Proc.Wait(); err != nil {
-		return err
+	return err
+}
+
+func (ec *ExecAsyncCommand) waitProc() error {
+
+	//let the output goroutines drain the pipes before they are closed
+	ec.ioWait.Wait()
+
+	proc := ec.process()
+
+	if ec.dryRun {
+		return nil
+	} else if proc == nil {
+		return errors.New("Unable to wait for a command that has not been started")
 	}
 
-	return nil
+	err := proc.Wait()
+
+	ec.audit(err)
+
+	return err
+}
+
+func (ec *ExecAsyncCommand) ExitCode() int {
+	proc := ec.process()
+	if ec.dryRun {
+		return 0
+	} else if proc == nil {
+		return -1
+	}
+	return proc.ExitCode()
+}
+
+func (ec *ExecAsyncCommand) Pid() int {
+	proc := ec.process()
+	if proc == nil {
+		return 0
+	}
+	return proc.Pid()
+}
+
+// process returns the running attempt, a retry replaces it while signal forwarding or a batch may be reading it
+func (ec *ExecAsyncCommand) process() ExecProcess {
+	ec.procLock.Lock()
+	defer ec.procLock.Unlock()
+	return ec.proc
+}
+
+func (ec *ExecAsyncCommand) setProcess(proc ExecProcess) {
+	ec.procLock.Lock()
+	defer ec.procLock.Unlock()
+	ec.proc = proc
 }
 
+// BindSigIntHandler sends the command a SIGTERM whenever this process receives a SIGINT or SIGTERM while it runs, see
+// ForwardSignals for other signals.
 func (ec *ExecAsyncCommand) BindSigIntHandler() *ExecAsyncCommand {
-	ec.intBound = true
-	ec.intChan = make(chan os.Signal)
-	signal.Notify(ec.intChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-ec.intChan
-		ec.Proc.Process.Signal(syscall.SIGTERM)
-	}()
-	return ec
+	return ec.ForwardSignal(os.Interrupt, syscall.SIGTERM).ForwardSignal(syscall.SIGTERM, syscall.SIGTERM)
 }
 
 func (ex *execUtils) CreateExecCommand(path string, args ...string) *exec.Cmd {
@@ -298,108 +627,113 @@ func (ex *execUtils) CreateExecCommand(path string, args ...string) *exec.Cmd {
 
 }
 
+// ExecCommandShowStdErr runs the command and writes its STDERR to STDERR of this process when it fails. The exec.Cmd
+// that ran it is returned, it is nil when no process was started by this one: in dry-run mode, with an Executor other
+// than the local one (e.g. FakeExecutor or SSHExecutor) or when the command could not be started.
 func (ex *execUtils) ExecCommandShowStdErr(path string, args ...string) (*exec.Cmd, error) {
 
-	cmd := ex.CreateExecCommand(path, args...)
+	return ex.execCommandShowStdErr(nil, path, args...)
+
+}
+
+func (ex *execUtils) execCommandShowStdErr(retry *RetryPolicy, path string, args ...string) (*exec.Cmd, error) {
 
-	var out bytes.Buffer
-	cmd.Stderr = &out
+	pr := ex.CreateAsyncCommand(path, true, args...).Retry(retry)
+	pr.CaptureStdoutAndStdErr(false, false)
 
-	err := cmd.Run()
+	err := pr.StartAndWait()
 
 	if err != nil {
 
-		log.Print(out.String())
+		os.Stderr.Write(pr.GetStderrBuffer())
 
-		return cmd, err
+		return pr.localCmd(), err
 
 	}
 
-	return cmd, nil
+	return pr.localCmd(), nil
 
 }
 
+// localCmd returns Proc once it has been run by the local executor, callers of ExecCommandShowStdErr expect its
+// ProcessState to be set
+func (ec *ExecAsyncCommand) localCmd() *exec.Cmd {
+	if ec.Proc == nil || ec.Proc.ProcessState == nil {
+		return nil
+	}
+	return ec.Proc
+}
+
 func (ex *execUtils) ExecCommandShowStdErrReturnOutput(path string, args ...string) (string, error) {
 
-	cmd := exec.Command(path, args...)
+	return ex.execCommandShowStdErrReturnOutput(nil, path, args...)
+
+}
+
+func (ex *execUtils) execCommandShowStdErrReturnOutput(retry *RetryPolicy, path string, args ...string) (string, error) {
 
-	var out bytes.Buffer
-	cmd.Stderr = &out
+	pr := ex.CreateAsyncCommand(path, false, args...).Retry(retry)
+	pr.CaptureStdoutAndStdErr(false, false)
 
-	stdout, err := cmd.Output()
+	err := pr.StartAndWait()
 
 	if err != nil {
 
-		log.Print(out.String())
+		os.Stderr.Write(pr.GetStderrBuffer())
 
 		return "", err
 
 	}
 
-	return string(stdout), nil
+	return string(pr.GetStdoutBuffer()), nil
 
 }
 
 func (ex *execUtils) RunCommandShowStdErr(path string, args ...string) error {
 
-	cmd := exec.Command(path, args...)
-
-	var out bytes.Buffer
-	cmd.Stderr = &out
-
-	err := cmd.Run()
-
-	if err != nil {
+	_, err := ex.ExecCommandShowStdErr(path, args...)
 
-		log.Print(out.String())
+	return err
 
-		return err
+}
 
-	}
+func (ex *execUtils) RunCommandAsyncOutput(path string, errOnly bool, args ...string) error {
 
-	return nil
+	return ex.runCommandAsyncOutput(nil, path, errOnly, args...)
 
 }
 
-func (ex *execUtils) RunCommandAsyncOutput(path string, errOnly bool, args ...string) error {
+func (ex *execUtils) runCommandAsyncOutput(retry *RetryPolicy, path string, errOnly bool, args ...string) error {
+
+	pr := ex.CreateAsyncCommand(path, errOnly, args...).Retry(retry)
 
-	pr := ex.CreateAsyncCommand(path, errOnly, args...)
+	pr.attachOutput(func() {
+		if !errOnly {
+			scanner := bufio.NewScanner(pr.stdoutSource())
+			pr.ioWait.Add(1)
+			go func() {
+				defer pr.ioWait.Done()
+				for scanner.Scan() {
+					fmt.Fprintln(os.Stdout, scanner.Text())
+				}
+			}()
+		}
 
-	if !errOnly {
-		scanner := bufio.NewScanner(pr.reader)
+		errScanner := bufio.NewScanner(pr.stderrSource())
+		pr.ioWait.Add(1)
 		go func() {
-			for scanner.Scan() {
-				log.Print(scanner.Text())
+			defer pr.ioWait.Done()
+			for errScanner.Scan() {
+				fmt.Fprintln(os.Stderr, errScanner.Text())
 			}
 		}()
-	}
+	})
 
-	errScanner := bufio.NewScanner(pr.error)
-	go func() {
-		for errScanner.Scan() {
-			log.Print(errScanner.Text())
-		}
-	}()
+	pr.BindSigIntHandler()
 
-	if !errOnly {
-		defer pr.reader.Close()
-	}
-
-	defer pr.writer.Close()
-	defer pr.error.Close()
-
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		pr.Proc.Process.Signal(syscall.SIGTERM)
-	}()
-
-	defer close(c)
-
-	if err := pr.Proc.Start(); err != nil {
+	if err := pr.Start(); err != nil {
 		return err
-	} else if err := pr.Proc.Wait(); err != nil {
+	} else if err := pr.Wait(); err != nil {
 		return err
 	}
 
@@ -410,10 +744,9 @@ func (ex *execUtils) RunCommandAsyncOutput(path string, errOnly bool, args ...st
 func (ex *execUtils) CreateAsyncCommand(path string, errOnly bool, args ...string) *ExecAsyncCommand {
 
 	pr := ExecAsyncCommand{
-		path:     path,
-		args:     args,
-		errOnly:  errOnly,
-		intBound: false,
+		path:    path,
+		args:    args,
+		errOnly: errOnly,
 	}
 
 	pr.init()
@@ -424,26 +757,7 @@ func (ex *execUtils) CreateAsyncCommand(path string, errOnly bool, args ...strin
 
 func (ex *execUtils) CheckSudo() bool {
 
-	cmd := exec.Command("id", "-u")
-	output, err := cmd.Output()
-
-	if err != nil {
-		return false
-	}
-
-	// output has trailing \n
-	// need to remove the \n
-	// otherwise it will cause error for strconv.Atoi
-	// log.Println(output[:len(output)-1])
-
-	// 0 = root, 501 = non-root user
-	i, err := strconv.Atoi(string(output[:len(output)-1]))
-
-	if i != 0 || err != nil {
-		return false
-	} else {
-		return true
-	}
+	return Privileges.IsRoot()
 
 }
 
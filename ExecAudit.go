@@ -0,0 +1,307 @@
+// +build !js
+
+package vutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is written to the audit log as a single JSON line for every process started, each attempt of a
+// retried command has its own record.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+	UID  int       `json:"uid"`
+	//SudoUser is who ran this process through sudo, if anyone
+	SudoUser     string   `json:"sudo_user,omitempty"`
+	Host         string   `json:"host"`
+	PID          int      `json:"pid"`
+	Cwd          string   `json:"cwd"`
+	Argv         []string `json:"argv"`
+	EnvKeys      []string `json:"env_keys"`
+	Sudo         bool     `json:"sudo"`
+	Privilege    string   `json:"privilege,omitempty"`
+	Attempt      int      `json:"attempt"`
+	ChildPID     int      `json:"child_pid,omitempty"`
+	ExitCode     int      `json:"exit_code"`
+	Error        string   `json:"error,omitempty"`
+	Duration     float64  `json:"duration_seconds"`
+	StdoutSHA256 string   `json:"stdout_sha256,omitempty"`
+	StdoutBytes  int64    `json:"stdout_bytes"`
+	StderrSHA256 string   `json:"stderr_sha256,omitempty"`
+	StderrBytes  int64    `json:"stderr_bytes"`
+}
+
+var (
+	defaultAuditRedactPatterns = []string{
+		`(?i)(?:password|passwd|secret|token|api[_-]?key|credential)[^=]*=(.+)`,
+		`(?i)authorization:\s*(.+)`,
+		`://[^/:@\s]+:([^/@\s]+)@`,
+	}
+	defaultAuditRedactAfterPatterns = []string{
+		`(?i)^--?(?:password|passwd|secret|token|api[_-]?key|auth[_-]?token)$`,
+	}
+)
+
+// AuditLog appends an AuditRecord per executed process to a sink as JSON Lines. Arguments matching the redaction
+// patterns are redacted before they are written, environment values are never written.
+type AuditLog struct {
+	lock        sync.Mutex
+	w           io.Writer
+	closer      io.Closer
+	redact      []*regexp.Regexp
+	redactAfter []*regexp.Regexp
+	user        string
+	uid         int
+	host        string
+}
+
+// NewAuditLog writes records to w with the default redaction patterns, which cover key=value style arguments and URLs
+// with passwords as well as the argument after flags such as --password or --token.
+func NewAuditLog(w io.Writer) *AuditLog {
+
+	al := &AuditLog{
+		w:   w,
+		uid: os.Getuid(),
+	}
+
+	if u, err := user.Current(); err == nil {
+		al.user = u.Username
+	} else {
+		al.user = strconv.Itoa(al.uid)
+	}
+
+	al.host, _ = os.Hostname()
+
+	return al.RedactArgs(defaultAuditRedactPatterns...).RedactArgsAfter(defaultAuditRedactAfterPatterns...)
+
+}
+
+// OpenAuditLog appends records to the file at path, creating it readable only by this user.
+func OpenAuditLog(path string) (*AuditLog, error) {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	al := NewAuditLog(f)
+	al.closer = f
+
+	return al, nil
+
+}
+
+// RedactArgs adds patterns whose matches in any argument are redacted, when a pattern has a group only the first
+// group is redacted, e.g. `--client-secret=(.+)`.
+func (al *AuditLog) RedactArgs(patterns ...string) *AuditLog {
+	for _, pattern := range patterns {
+		al.redact = append(al.redact, regexp.MustCompile(pattern))
+	}
+	return al
+}
+
+// RedactArgsAfter adds patterns for flags whose following argument is redacted completely.
+func (al *AuditLog) RedactArgsAfter(patterns ...string) *AuditLog {
+	for _, pattern := range patterns {
+		al.redactAfter = append(al.redactAfter, regexp.MustCompile(pattern))
+	}
+	return al
+}
+
+func (al *AuditLog) RedactArgv(argv []string) []string {
+
+	redacted := make([]string, len(argv))
+
+	for i, arg := range argv {
+
+		if i > 0 && matchesAny(al.redactAfter, argv[i-1]) {
+			redacted[i] = RedactedValue
+			continue
+		}
+
+		for _, pattern := range al.redact {
+			arg = redactMatches(pattern, arg)
+		}
+
+		redacted[i] = arg
+
+	}
+
+	return redacted
+
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactMatches replaces the first group of every match of pattern in s, or the whole match when it has no groups
+func redactMatches(pattern *regexp.Regexp, s string) string {
+
+	matches := pattern.FindAllStringSubmatchIndex(s, -1)
+
+	for i := len(matches) - 1; i >= 0; i-- {
+		start, end := matches[i][0], matches[i][1]
+		if len(matches[i]) > 2 {
+			start, end = matches[i][2], matches[i][3]
+		}
+		if start >= 0 {
+			s = s[:start] + RedactedValue + s[end:]
+		}
+	}
+
+	return s
+
+}
+
+// Record fills in who ran the command and writes rec as a line of JSON.
+func (al *AuditLog) Record(rec *AuditRecord) error {
+
+	rec.User, rec.UID, rec.Host, rec.PID = al.user, al.uid, al.host, os.Getpid()
+	rec.SudoUser = os.Getenv("SUDO_USER")
+	rec.Argv = al.RedactArgv(rec.Argv)
+
+	line, err := json.Marshal(rec)
+
+	if err != nil {
+		return err
+	}
+
+	al.lock.Lock()
+	defer al.lock.Unlock()
+
+	_, err = al.w.Write(append(line, '\n'))
+
+	return err
+
+}
+
+func (al *AuditLog) Close() error {
+	if al.closer == nil {
+		return nil
+	}
+	return al.closer.Close()
+}
+
+// SetAuditLog records every process started through ExecAsyncCommand, the RunCommand* helpers and the ProcessManager
+// from now on to al, nil stops auditing. Commands in dry run mode are not audited as nothing is run.
+func (ex *execUtils) SetAuditLog(al *AuditLog) {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	ex.audit = al
+}
+
+func (ex *execUtils) AuditLog() *AuditLog {
+	ex.lock.RLock()
+	defer ex.lock.RUnlock()
+	return ex.audit
+}
+
+// outputDigest hashes a stream of output as it is read, streams nothing reads are left out of the record
+type outputDigest struct {
+	hash  hash.Hash
+	count int64
+	read  bool
+}
+
+func newOutputDigest() *outputDigest {
+	return &outputDigest{
+		hash: sha256.New(),
+	}
+}
+
+func (od *outputDigest) Write(p []byte) (int, error) {
+	od.count += int64(len(p))
+	return od.hash.Write(p)
+}
+
+func (od *outputDigest) sum() (string, int64) {
+	if od == nil || !od.read {
+		return "", 0
+	}
+	return hex.EncodeToString(od.hash.Sum(nil)), od.count
+}
+
+// audit records the attempt that just ended with err, the output must have been drained
+func (ec *ExecAsyncCommand) audit(err error) {
+
+	al := Exec.AuditLog()
+
+	if al == nil {
+		return
+	}
+
+	rec := &AuditRecord{
+		Time:     ec.attemptStart,
+		Cwd:      ec.Proc.Dir,
+		Argv:     append([]string{}, ec.Proc.Args...),
+		Sudo:     ec.privilege != nil,
+		Attempt:  len(ec.attempts) + 1,
+		ChildPID: ec.Pid(),
+		ExitCode: ec.ExitCode(),
+		Duration: time.Since(ec.attemptStart).Seconds(),
+	}
+
+	if rec.Cwd == "" {
+		rec.Cwd, _ = os.Getwd()
+	}
+
+	if ec.privilege != nil {
+		rec.Privilege = ec.privilege.Name()
+	}
+
+	if err != nil {
+		rec.Error = err.Error()
+		if rec.ExitCode == 0 {
+			rec.ExitCode = -1
+		}
+	}
+
+	env := ec.Proc.Env
+
+	if env == nil {
+		env = os.Environ()
+	}
+
+	rec.EnvKeys = envKeys(env)
+	rec.StdoutSHA256, rec.StdoutBytes = ec.stdoutDigest.sum()
+	rec.StderrSHA256, rec.StderrBytes = ec.stderrDigest.sum()
+
+	if err := al.Record(rec); err != nil {
+		logWarn("Unable to write audit record", LogF("command", ec.path), LogF("error", err))
+	}
+
+}
+
+func envKeys(env []string) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, kv := range env {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,149 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogRedactArgv(t *testing.T) {
+
+	al := NewAuditLog(ioutil.Discard).RedactArgs(`--client-secret=(.+)`).RedactArgsAfter(`^-k$`)
+
+	tests := []struct {
+		argv []string
+		want []string
+	}{
+		{[]string{"curl", "-s", "https://example.com"}, []string{"curl", "-s", "https://example.com"}},
+		{[]string{"login", "--password", "hunter2", "user"}, []string{"login", "--password", RedactedValue, "user"}},
+		{[]string{"tool", "API_KEY=abc", "name=x"}, []string{"tool", "API_KEY=" + RedactedValue, "name=x"}},
+		{[]string{"git", "clone", "https://me:pw@example.com/repo"}, []string{"git", "clone", "https://me:" + RedactedValue + "@example.com/repo"}},
+		{[]string{"curl", "-H", "Authorization: Bearer abc"}, []string{"curl", "-H", "Authorization: " + RedactedValue}},
+		{[]string{"app", "--client-secret=s3", "-k", "key"}, []string{"app", "--client-secret=" + RedactedValue, "-k", RedactedValue}},
+	}
+
+	for _, tt := range tests {
+		if got := al.RedactArgv(tt.argv); !equalStrings(got, tt.want) {
+			t.Errorf("RedactArgv(%q) = %q, want %q", tt.argv, got, tt.want)
+		}
+	}
+
+}
+
+func TestAuditLogRecordsCommands(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	Exec.SetAuditLog(NewAuditLog(&buf))
+	defer Exec.SetAuditLog(nil)
+
+	env := NewEnv().Set("PATH", os.Getenv("PATH")).Set("VUTILS_AUDIT_TOKEN", "hunter2")
+
+	ok := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "printf hello; exit 0", "--token", "t0k3n").UseEnv(env).CaptureStdoutAndStdErr(false, false)
+	failed := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "exit 4")
+	missing := Exec.CreateAsyncCommand("/nonexistent/vutils-test", false)
+
+	if err := ok.StartAndWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	failed.StartAndWait()
+	missing.StartAndWait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 3 {
+		t.Fatalf("audit log has %d records, want 3:\n%s", len(lines), buf.String())
+	}
+
+	records := make([]AuditRecord, len(lines))
+
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hello := sha256.Sum256([]byte("hello"))
+
+	if rec := records[0]; rec.ExitCode != 0 || rec.Error != "" || rec.ChildPID == 0 || rec.PID != os.Getpid() || rec.Attempt != 1 {
+		t.Errorf("record of the successful command = %+v", rec)
+	} else if !equalStrings(rec.Argv, []string{"/bin/sh", "-c", "printf hello; exit 0", "--token", RedactedValue}) {
+		t.Errorf("argv = %q", rec.Argv)
+	} else if !equalStrings(rec.EnvKeys, []string{"PATH", "VUTILS_AUDIT_TOKEN"}) || strings.Contains(lines[0], "hunter2") {
+		t.Errorf("env keys = %q in %s", rec.EnvKeys, lines[0])
+	} else if rec.StdoutSHA256 != hex.EncodeToString(hello[:]) || rec.StdoutBytes != 5 {
+		t.Errorf("stdout digest = %s (%d bytes)", rec.StdoutSHA256, rec.StdoutBytes)
+	}
+
+	if rec := records[1]; rec.ExitCode != 4 || rec.Error == "" || rec.StdoutSHA256 != "" {
+		t.Errorf("record of the failed command = %+v", rec)
+	}
+
+	if rec := records[2]; rec.ExitCode != -1 || rec.Error == "" || rec.ChildPID != 0 {
+		t.Errorf("record of the command that did not start = %+v", rec)
+	}
+
+}
+
+func TestAuditLogDryRun(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	Exec.SetAuditLog(NewAuditLog(&buf))
+	defer Exec.SetAuditLog(nil)
+
+	Exec.SetDryRun(true)
+	defer Exec.SetDryRun(false)
+	defer Exec.DryRunPlan().Reset()
+
+	if err := Exec.CreateAsyncCommand("/bin/true", false).StartAndWait(); err != nil {
+		t.Fatal(err)
+	} else if buf.Len() != 0 {
+		t.Errorf("a dry run was audited: %s", buf.String())
+	}
+
+}
+
+func TestOpenAuditLog(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-audit")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+
+	for i := 0; i < 2; i++ {
+		al, err := OpenAuditLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		al.Record(&AuditRecord{Argv: []string{"true"}})
+		al.Close()
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("audit log mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	if data, _ := ioutil.ReadFile(path); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("audit log was not appended to:\n%s", data)
+	}
+
+}
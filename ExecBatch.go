@@ -0,0 +1,291 @@
+// +build !js
+
+package vutils
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BatchResult struct {
+	//Index of the command in the batch
+	Index    int
+	Cmd      *ExecAsyncCommand
+	ExitCode int
+	Err      error
+	Started  time.Time
+	Duration time.Duration
+	//Skipped commands were never started because the batch failed fast
+	Skipped bool
+	//Cancelled commands were killed because another command failed
+	Cancelled bool
+}
+
+func (br *BatchResult) Success() bool {
+	return br.Err == nil && !br.Skipped && !br.Cancelled
+}
+
+type BatchProgress struct {
+	Total     int
+	Running   int
+	Completed int
+	Failed    int
+	Skipped   int
+	//Last is the result that triggered this progress update
+	Last *BatchResult
+}
+
+// BatchError lists every command of a batch that failed, commands that were skipped or cancelled are only counted.
+type BatchError struct {
+	Failures  []*BatchResult
+	Total     int
+	Skipped   int
+	Cancelled int
+}
+
+func (be *BatchError) Error() string {
+
+	lines := []string{fmt.Sprintf("%d of %d commands failed", len(be.Failures), be.Total)}
+
+	if be.Skipped > 0 || be.Cancelled > 0 {
+		lines[0] += fmt.Sprintf(" (%d skipped, %d cancelled)", be.Skipped, be.Cancelled)
+	}
+
+	for _, failure := range be.Failures {
+		lines = append(lines, fmt.Sprintf("#%d %s: %s", failure.Index, strings.Join(failure.Cmd.Proc.Args, " "), failure.Err.Error()))
+	}
+
+	return strings.Join(lines, "\n")
+
+}
+
+// ExecBatch runs many commands with a limit on how many of them run at a time, see SetConcurrency.
+type ExecBatch struct {
+	commands    []*ExecAsyncCommand
+	concurrency int
+	failFast    bool
+	ordered     bool
+	onProgress  func(BatchProgress)
+	onResult    func(*BatchResult)
+	lock        sync.Mutex
+	stopped     bool
+	running     map[int]*ExecAsyncCommand
+}
+
+// CreateBatch runs the commands with up to one per CPU at a time, results are returned in the order of the commands.
+func (ex *execUtils) CreateBatch(commands ...*ExecAsyncCommand) *ExecBatch {
+	return &ExecBatch{
+		commands:    commands,
+		concurrency: runtime.NumCPU(),
+		ordered:     true,
+		running:     map[int]*ExecAsyncCommand{},
+	}
+}
+
+func (b *ExecBatch) Add(commands ...*ExecAsyncCommand) *ExecBatch {
+	b.commands = append(b.commands, commands...)
+	return b
+}
+
+func (b *ExecBatch) SetConcurrency(n int) *ExecBatch {
+	if n < 1 {
+		n = 1
+	}
+	b.concurrency = n
+	return b
+}
+
+// FailFast stops the batch at the first failure, commands not yet started are skipped and running ones are killed. A
+// killed command with a retry policy can still be retried by it.
+func (b *ExecBatch) FailFast(enabled bool) *ExecBatch {
+	b.failFast = enabled
+	return b
+}
+
+// Ordered controls whether results (from Run and OnResult) come in the order of the commands or as they complete.
+func (b *ExecBatch) Ordered(enabled bool) *ExecBatch {
+	b.ordered = enabled
+	return b
+}
+
+// OnProgress is called after every command finishes or is skipped, calls are never concurrent.
+func (b *ExecBatch) OnProgress(fn func(BatchProgress)) *ExecBatch {
+	b.onProgress = fn
+	return b
+}
+
+// OnResult receives every result as soon as it is available in the order set by Ordered, calls are never concurrent.
+func (b *ExecBatch) OnResult(fn func(*BatchResult)) *ExecBatch {
+	b.onResult = fn
+	return b
+}
+
+// Run starts the commands and waits for all of them, the error is a *BatchError when any command failed.
+func (b *ExecBatch) Run() ([]*BatchResult, error) {
+
+	total := len(b.commands)
+	results := make(chan *BatchResult, total)
+	slots := make(chan struct{}, b.concurrency)
+
+	go func() {
+		for i, cmd := range b.commands {
+			slots <- struct{}{}
+			go func(i int, cmd *ExecAsyncCommand) {
+				defer func() { <-slots }()
+				result := b.run(i, cmd)
+				//stop before the slot is freed so the next command is skipped rather than started
+				if b.failFast && result.Err != nil && !result.Cancelled {
+					b.stop()
+				}
+				results <- result
+			}(i, cmd)
+		}
+	}()
+
+	progress := BatchProgress{
+		Total: total,
+	}
+	collected := make([]*BatchResult, 0, total)
+	pending := map[int]*BatchResult{}
+	next := 0
+	batchErr := &BatchError{
+		Total: total,
+	}
+
+	for len(collected) < total {
+
+		result := <-results
+
+		switch {
+		case result.Skipped:
+			progress.Skipped++
+			batchErr.Skipped++
+		case result.Cancelled:
+			progress.Completed++
+			batchErr.Cancelled++
+		case result.Err != nil:
+			progress.Completed++
+			progress.Failed++
+			batchErr.Failures = append(batchErr.Failures, result)
+		default:
+			progress.Completed++
+		}
+
+		b.lock.Lock()
+		progress.Running = len(b.running)
+		b.lock.Unlock()
+
+		progress.Last = result
+
+		if b.onProgress != nil {
+			b.onProgress(progress)
+		}
+
+		if !b.ordered {
+			collected = append(collected, result)
+			if b.onResult != nil {
+				b.onResult(result)
+			}
+			continue
+		}
+
+		//hold on to results that completed ahead of earlier commands
+		pending[result.Index] = result
+		for pending[next] != nil {
+			collected = append(collected, pending[next])
+			if b.onResult != nil {
+				b.onResult(pending[next])
+			}
+			delete(pending, next)
+			next++
+		}
+
+	}
+
+	if len(batchErr.Failures) > 0 {
+		return collected, batchErr
+	}
+
+	return collected, nil
+
+}
+
+func (b *ExecBatch) run(index int, cmd *ExecAsyncCommand) *BatchResult {
+
+	result := &BatchResult{
+		Index: index,
+		Cmd:   cmd,
+	}
+
+	b.lock.Lock()
+	stopped := b.stopped
+	b.lock.Unlock()
+
+	if stopped {
+		result.Skipped = true
+		result.ExitCode = -1
+		return result
+	}
+
+	result.Started = time.Now()
+
+	result.Err = cmd.startAndWait(func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.stopped {
+			//the batch failed while this command was starting
+			result.Cancelled = true
+			cmd.Signal(os.Kill)
+			return
+		}
+		b.running[index] = cmd
+	})
+
+	b.lock.Lock()
+	if _, ok := b.running[index]; !ok && b.stopped && result.Err != nil {
+		result.Cancelled = true
+	}
+	delete(b.running, index)
+	b.lock.Unlock()
+
+	result.Duration = time.Since(result.Started)
+	result.ExitCode = cmd.ExitCode()
+
+	if result.Err != nil && result.ExitCode == 0 {
+		result.ExitCode = -1
+	}
+
+	return result
+
+}
+
+// stop skips every command not yet started and kills the running ones
+func (b *ExecBatch) stop() {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.stopped {
+		return
+	}
+
+	b.stopped = true
+
+	for index, cmd := range b.running {
+		if err := cmd.Signal(os.Kill); err != nil {
+			logWarn("Unable to kill batch command", LogF("command", cmd.path), LogF("error", err))
+		}
+		//forget the command so its result is marked as cancelled
+		delete(b.running, index)
+	}
+
+}
+
+// RunBatch runs the commands with up to concurrency of them at a time, see CreateBatch for more control.
+func (ex *execUtils) RunBatch(concurrency int, commands ...*ExecAsyncCommand) ([]*BatchResult, error) {
+	return ex.CreateBatch(commands...).SetConcurrency(concurrency).Run()
+}
@@ -0,0 +1,132 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchRun(t *testing.T) {
+
+	progress := []BatchProgress{}
+	onResult := []int{}
+
+	results, err := Exec.CreateBatch(
+		shellStage("sleep 0.2"),
+		shellStage("exit 3"),
+		shellStage("true"),
+	).SetConcurrency(3).
+		OnProgress(func(p BatchProgress) { progress = append(progress, p) }).
+		OnResult(func(r *BatchResult) { onResult = append(onResult, r.Index) }).
+		Run()
+
+	batchErr, ok := err.(*BatchError)
+
+	if !ok {
+		t.Fatalf("Run returned %v, want a *BatchError", err)
+	} else if len(batchErr.Failures) != 1 || batchErr.Failures[0].Index != 1 || batchErr.Total != 3 {
+		t.Errorf("BatchError = %+v", batchErr)
+	} else if !strings.HasPrefix(err.Error(), "1 of 3 commands failed\n#1 /bin/sh -c exit 3: ") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Run returned %d results", len(results))
+	}
+
+	for i, want := range []int{0, 3, 0} {
+		if results[i].Index != i || results[i].ExitCode != want || results[i].Success() != (want == 0) {
+			t.Errorf("result %d: index %d exit code %d success %t, want exit code %d", i, results[i].Index, results[i].ExitCode, results[i].Success(), want)
+		}
+	}
+
+	if fmt.Sprint(onResult) != "[0 1 2]" {
+		t.Errorf("OnResult saw %v, want the order of the commands", onResult)
+	}
+
+	if last := progress[len(progress)-1]; len(progress) != 3 || last.Completed != 3 || last.Failed != 1 || last.Running != 0 || last.Total != 3 {
+		t.Errorf("progress = %+v", progress)
+	} else if progress[0].Last.Index == 0 {
+		t.Error("progress was not reported as commands completed")
+	}
+
+}
+
+func TestBatchUnordered(t *testing.T) {
+
+	results, err := Exec.CreateBatch(shellStage("sleep 0.3"), shellStage("true")).SetConcurrency(2).Ordered(false).Run()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(results) != 2 || results[0].Index != 1 || results[1].Index != 0 {
+		t.Errorf("results are not in the order the commands completed: %d then %d", results[0].Index, results[1].Index)
+	}
+
+}
+
+func TestBatchConcurrency(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-batch")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	commands := []*ExecAsyncCommand{}
+
+	for _, name := range []string{"a", "b", "c"} {
+		commands = append(commands, shellStage("echo "+name+" >> "+ShellQuote(log)+"; sleep 0.05; echo "+name+" >> "+ShellQuote(log)))
+	}
+
+	if _, err := Exec.RunBatch(1, commands...); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ioutil.ReadFile(log); string(data) != "a\na\nb\nb\nc\nc\n" {
+		t.Errorf("commands overlapped with a concurrency of 1:\n%s", data)
+	}
+
+}
+
+func TestBatchFailFast(t *testing.T) {
+
+	results, err := Exec.CreateBatch(shellStage("exit 1"), shellStage("true"), shellStage("true")).
+		SetConcurrency(1).
+		FailFast(true).
+		Run()
+
+	if batchErr, ok := err.(*BatchError); !ok || batchErr.Skipped != 2 || len(batchErr.Failures) != 1 {
+		t.Errorf("Run returned %v", err)
+	} else if !results[1].Skipped || !results[2].Skipped || results[1].ExitCode != -1 || results[1].Success() {
+		t.Errorf("commands after the failure were not skipped: %+v %+v", results[1], results[2])
+	}
+
+	start := time.Now()
+
+	results, err = Exec.CreateBatch(shellStage("exec sleep 5"), shellStage("sleep 0.1; exit 2")).
+		SetConcurrency(2).
+		FailFast(true).
+		Run()
+
+	if time.Since(start) > 4*time.Second {
+		t.Errorf("the running command was not killed")
+	}
+
+	if batchErr, ok := err.(*BatchError); !ok || batchErr.Cancelled != 1 || len(batchErr.Failures) != 1 || batchErr.Failures[0].Index != 1 {
+		t.Errorf("Run returned %v", err)
+	} else if !results[0].Cancelled || results[0].Success() {
+		t.Errorf("the running command was not cancelled: %+v", results[0])
+	} else if !strings.Contains(err.Error(), "(0 skipped, 1 cancelled)") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+}
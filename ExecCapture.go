@@ -0,0 +1,326 @@
+// +build !js
+
+package vutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CaptureOptions bound how much captured output is kept. The zero value keeps everything in memory.
+type CaptureOptions struct {
+	//MaxBytes caps the output kept in memory, the first HeadBytes and the last MaxBytes-HeadBytes are retained
+	MaxBytes int
+	//HeadBytes defaults to half of MaxBytes
+	HeadBytes int
+	//MaxLines keeps a ring of the last MaxLines lines available through Lines, each cut short at MaxBytes when it is set
+	MaxLines int
+	//SpillPath receives the complete output, it is truncated when the command (or a retry of it) starts. STDOUT and
+	//STDERR cannot share it, starting fails when they do
+	SpillPath string
+	//SpillToTemp writes the complete output to a new temporary file, see SpillFile
+	SpillToTemp bool
+}
+
+// CaptureBuffer holds the captured output of a stream, all of its methods are safe to call while the command is
+// running.
+type CaptureBuffer struct {
+	lock      sync.Mutex
+	options   CaptureOptions
+	head      []byte
+	headMax   int
+	tail      *tailBuffer
+	total     int64
+	lines     []string
+	partial   []byte
+	spill     *os.File
+	spillPath string
+	spillErr  error
+}
+
+func NewCaptureBuffer(options *CaptureOptions) *CaptureBuffer {
+
+	cb := &CaptureBuffer{
+		headMax: -1,
+	}
+
+	if options != nil {
+		cb.options = *options
+	}
+
+	if cb.options.MaxBytes > 0 {
+		cb.headMax = cb.options.HeadBytes
+		if cb.headMax <= 0 || cb.headMax > cb.options.MaxBytes {
+			cb.headMax = cb.options.MaxBytes / 2
+		}
+		cb.tail = newTailBuffer(cb.options.MaxBytes - cb.headMax)
+	}
+
+	cb.openSpill()
+
+	return cb
+
+}
+
+func (cb *CaptureBuffer) openSpill() {
+
+	var err error
+
+	if cb.options.SpillPath != "" {
+		cb.spill, err = os.OpenFile(cb.options.SpillPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	} else if cb.options.SpillToTemp {
+		cb.spill, err = ioutil.TempFile("", "vutils-capture-")
+	}
+
+	if err != nil {
+		cb.spillErr = err
+		logWarn("Unable to open capture spill file", LogF("error", err))
+	} else if cb.spill != nil {
+		cb.spillPath = cb.spill.Name()
+	}
+
+}
+
+func (cb *CaptureBuffer) Write(p []byte) (int, error) {
+
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	cb.total += int64(len(p))
+
+	if cb.spill != nil {
+		if _, err := cb.spill.Write(p); err != nil && cb.spillErr == nil {
+			cb.spillErr = err
+		}
+	}
+
+	data := p
+
+	if cb.headMax < 0 {
+		cb.head = append(cb.head, data...)
+	} else {
+		if room := cb.headMax - len(cb.head); room > 0 {
+			if room > len(data) {
+				room = len(data)
+			}
+			cb.head = append(cb.head, data[:room]...)
+			data = data[room:]
+		}
+		if len(data) > 0 {
+			cb.tail.Write(data)
+		}
+	}
+
+	if cb.options.MaxLines > 0 {
+		cb.addLines(p)
+	}
+
+	return len(p), nil
+
+}
+
+func (cb *CaptureBuffer) addLines(p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			cb.appendPartial(p)
+			return
+		}
+		cb.appendPartial(p[:i])
+		cb.lines = append(cb.lines, string(cb.partial))
+		cb.partial = cb.partial[:0]
+		if len(cb.lines) > cb.options.MaxLines {
+			cb.lines = cb.lines[len(cb.lines)-cb.options.MaxLines:]
+		}
+		p = p[i+1:]
+	}
+}
+
+// appendPartial adds to the line being written, with MaxBytes the line is cut short there so output without newlines
+// (a progress bar, binary data) cannot grow it without bound
+func (cb *CaptureBuffer) appendPartial(p []byte) {
+	if cb.options.MaxBytes > 0 && len(cb.partial)+len(p) > cb.options.MaxBytes {
+		p = p[:maxInt(cb.options.MaxBytes-len(cb.partial), 0)]
+	}
+	cb.partial = append(cb.partial, p...)
+}
+
+// Bytes returns the retained output, when output was dropped a marker noting how much separates the head and tail.
+func (cb *CaptureBuffer) Bytes() []byte {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	out := append([]byte{}, cb.head...)
+	if cb.tail != nil {
+		if omitted := cb.omitted(); omitted > 0 {
+			out = append(out, fmt.Sprintf("\n[... %d bytes omitted ...]\n", omitted)...)
+		}
+		out = append(out, cb.tail.Bytes()...)
+	}
+	return out
+}
+
+func (cb *CaptureBuffer) String() string {
+	return string(cb.Bytes())
+}
+
+func (cb *CaptureBuffer) Head() []byte {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return append([]byte{}, cb.head...)
+}
+
+func (cb *CaptureBuffer) Tail() []byte {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.tail == nil {
+		return append([]byte{}, cb.head...)
+	}
+	return cb.tail.Bytes()
+}
+
+// Lines returns the last MaxLines lines, including an unterminated last line.
+func (cb *CaptureBuffer) Lines() []string {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	lines := append([]string{}, cb.lines...)
+	if len(cb.partial) > 0 {
+		lines = append(lines, string(cb.partial))
+		if cb.options.MaxLines > 0 && len(lines) > cb.options.MaxLines {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
+
+// Total is the number of bytes written, including any that were not retained.
+func (cb *CaptureBuffer) Total() int64 {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.total
+}
+
+func (cb *CaptureBuffer) Truncated() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.omitted() > 0
+}
+
+func (cb *CaptureBuffer) omitted() int64 {
+	if cb.tail == nil {
+		return 0
+	}
+	return cb.total - int64(len(cb.head)) - int64(cb.tail.max)
+}
+
+// SpillFile returns the path of the file holding the complete output, or an empty string if there is none.
+func (cb *CaptureBuffer) SpillFile() string {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.spillPath
+}
+
+func (cb *CaptureBuffer) SpillError() error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.spillErr
+}
+
+// RemoveSpillFile deletes the spill file, temporary spill files are not removed automatically.
+func (cb *CaptureBuffer) RemoveSpillFile() error {
+	cb.finish()
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.spillPath == "" {
+		return errors.New("Capture has no spill file")
+	}
+	return os.Remove(cb.spillPath)
+}
+
+// finish closes the spill file once the command and everything reading its output are done
+func (cb *CaptureBuffer) finish() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.spill != nil {
+		if err := cb.spill.Close(); err != nil && cb.spillErr == nil {
+			cb.spillErr = err
+		}
+		cb.spill = nil
+	}
+}
+
+// reset discards everything captured so far for a retry of the command, a temporary spill file is replaced
+func (cb *CaptureBuffer) reset() {
+	cb.finish()
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.options.SpillToTemp && cb.options.SpillPath == "" && cb.spillPath != "" {
+		os.Remove(cb.spillPath)
+	}
+	cb.head = nil
+	cb.total = 0
+	cb.lines = nil
+	cb.partial = nil
+	cb.spillPath = ""
+	cb.spillErr = nil
+	if cb.tail != nil {
+		cb.tail = newTailBuffer(cb.tail.max)
+	}
+	cb.openSpill()
+}
+
+// CaptureWithOptions captures like CaptureStdoutAndStdErr with the retention of each stream bounded by its options,
+// nil keeps everything in memory.
+func (ec *ExecAsyncCommand) CaptureWithOptions(combine bool, outputToStdIO bool, stdout *CaptureOptions, stderr *CaptureOptions) *ExecAsyncCommand {
+	if !ec.stdioCapture && !ec.stdioBound {
+		ec.captureErr = nil
+		if sharedSpillPath(stdout, stderr) {
+			ec.captureErr = errors.New(fmt.Sprintf("Unable to capture STDOUT and STDERR to the same spill file %s", stdout.SpillPath))
+			//neither stream spills so the file is left alone
+			stdout, stderr = withoutSpill(stdout), withoutSpill(stderr)
+		}
+		ec.stdoutOptions, ec.stderrOptions = stdout, stderr
+	}
+	return ec.CaptureStdoutAndStdErr(combine, outputToStdIO)
+}
+
+// sharedSpillPath reports whether both streams would spill to one file and truncate each other's output
+func sharedSpillPath(stdout *CaptureOptions, stderr *CaptureOptions) bool {
+	if stdout == nil || stderr == nil || stdout.SpillPath == "" || stderr.SpillPath == "" {
+		return false
+	}
+	outPath, outErr := filepath.Abs(stdout.SpillPath)
+	errPath, errErr := filepath.Abs(stderr.SpillPath)
+	if outErr != nil || errErr != nil {
+		return stdout.SpillPath == stderr.SpillPath
+	}
+	return outPath == errPath
+}
+
+func withoutSpill(options *CaptureOptions) *CaptureOptions {
+	copied := *options
+	copied.SpillPath = ""
+	copied.SpillToTemp = false
+	return &copied
+}
+
+// StdoutCapture returns the captured STDOUT or nil when it is not being captured.
+func (ec *ExecAsyncCommand) StdoutCapture() *CaptureBuffer {
+	return ec.stdoutWriter
+}
+
+func (ec *ExecAsyncCommand) StderrCapture() *CaptureBuffer {
+	return ec.stderrWriter
+}
+
+func (ec *ExecAsyncCommand) finishCapture() {
+	for _, cb := range []*CaptureBuffer{ec.stdoutWriter, ec.stderrWriter} {
+		if cb != nil {
+			cb.finish()
+		}
+	}
+}
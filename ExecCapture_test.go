@@ -0,0 +1,199 @@
+// +build !js
+
+package vutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureBuffer(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		options   *CaptureOptions
+		writes    []string
+		bytes     string
+		head      string
+		tail      string
+		lines     []string
+		truncated bool
+	}{
+		{
+			name:   "everything kept",
+			writes: []string{"one\n", "two\n"},
+			bytes:  "one\ntwo\n",
+			head:   "one\ntwo\n",
+			tail:   "one\ntwo\n",
+			lines:  []string{},
+		},
+		{
+			name:    "fits",
+			options: &CaptureOptions{MaxBytes: 10},
+			writes:  []string{"0123", "4567"},
+			bytes:   "01234567",
+			head:    "01234",
+			tail:    "567",
+			lines:   []string{},
+		},
+		{
+			name:      "head and tail",
+			options:   &CaptureOptions{MaxBytes: 8, HeadBytes: 3},
+			writes:    []string{"abcdef", "ghijkl", "mnop"},
+			bytes:     "abc\n[... 8 bytes omitted ...]\nlmnop",
+			head:      "abc",
+			tail:      "lmnop",
+			lines:     []string{},
+			truncated: true,
+		},
+		{
+			name:    "line ring",
+			options: &CaptureOptions{MaxLines: 2},
+			writes:  []string{"a\nb", "\nc\nd"},
+			bytes:   "a\nb\nc\nd",
+			head:    "a\nb\nc\nd",
+			tail:    "a\nb\nc\nd",
+			lines:   []string{"c", "d"},
+		},
+		{
+			name:      "partial line cut at MaxBytes",
+			options:   &CaptureOptions{MaxBytes: 4, MaxLines: 3},
+			writes:    []string{"ab\n", "cdefgh", "ij"},
+			bytes:     "ab\n[... 7 bytes omitted ...]\nij",
+			head:      "ab",
+			tail:      "ij",
+			lines:     []string{"ab", "cdef"},
+			truncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+
+		cb := NewCaptureBuffer(tt.options)
+		total := 0
+
+		for _, w := range tt.writes {
+			cb.Write([]byte(w))
+			total += len(w)
+		}
+
+		if got := cb.String(); got != tt.bytes {
+			t.Errorf("%s: Bytes = %q, want %q", tt.name, got, tt.bytes)
+		}
+
+		if got := string(cb.Head()); got != tt.head {
+			t.Errorf("%s: Head = %q, want %q", tt.name, got, tt.head)
+		}
+
+		if got := string(cb.Tail()); got != tt.tail {
+			t.Errorf("%s: Tail = %q, want %q", tt.name, got, tt.tail)
+		}
+
+		if got := cb.Lines(); tt.options != nil && tt.options.MaxLines > 0 && !equalStrings(got, tt.lines) {
+			t.Errorf("%s: Lines = %q, want %q", tt.name, got, tt.lines)
+		}
+
+		if cb.Total() != int64(total) || cb.Truncated() != tt.truncated {
+			t.Errorf("%s: Total %d truncated %t, want %d %t", tt.name, cb.Total(), cb.Truncated(), total, tt.truncated)
+		}
+
+		if cb.SpillFile() != "" || cb.RemoveSpillFile() == nil {
+			t.Errorf("%s: has a spill file %s", tt.name, cb.SpillFile())
+		}
+
+	}
+
+}
+
+func TestCaptureBufferSpill(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-capture")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	spillPath := filepath.Join(dir, "out.log")
+	cb := NewCaptureBuffer(&CaptureOptions{MaxBytes: 4, SpillPath: spillPath})
+
+	cb.Write([]byte("first attempt\n"))
+	cb.reset()
+	cb.Write([]byte("second attempt\n"))
+	cb.finish()
+
+	if data, err := ioutil.ReadFile(spillPath); err != nil || string(data) != "second attempt\n" {
+		t.Errorf("spill file holds %q, %v", data, err)
+	} else if cb.SpillFile() != spillPath || cb.SpillError() != nil {
+		t.Errorf("SpillFile = %s, SpillError = %v", cb.SpillFile(), cb.SpillError())
+	}
+
+	temp := NewCaptureBuffer(&CaptureOptions{SpillToTemp: true})
+	temp.Write([]byte("data"))
+	first := temp.SpillFile()
+	temp.reset()
+
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("reset kept the temporary spill file %s", first)
+	} else if err := temp.RemoveSpillFile(); err != nil || temp.SpillFile() == first {
+		t.Errorf("RemoveSpillFile returned %v for %s", err, temp.SpillFile())
+	}
+
+	missing := NewCaptureBuffer(&CaptureOptions{SpillPath: filepath.Join(dir, "missing", "out.log")})
+
+	if missing.SpillError() == nil {
+		t.Error("no SpillError for a spill file that cannot be created")
+	}
+
+}
+
+func TestCaptureSharedSpillPath(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-capture")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	spillPath := filepath.Join(dir, "out.log")
+
+	if err := ioutil.WriteFile(spillPath, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ec := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "echo out; echo err >&2").
+		CaptureWithOptions(false, false, &CaptureOptions{SpillPath: spillPath}, &CaptureOptions{SpillPath: filepath.Join(dir, ".", "out.log")})
+
+	if err := ec.Start(); err == nil || !strings.Contains(err.Error(), "same spill file") {
+		t.Errorf("Start returned %v, want an error about the shared spill file", err)
+	}
+
+	if data, _ := ioutil.ReadFile(spillPath); string(data) != "keep" {
+		t.Errorf("the shared spill file was truncated to %q", data)
+	}
+
+	tests := []struct {
+		stdout *CaptureOptions
+		stderr *CaptureOptions
+		shared bool
+	}{
+		{nil, nil, false},
+		{&CaptureOptions{SpillPath: "a"}, nil, false},
+		{&CaptureOptions{SpillPath: "a"}, &CaptureOptions{SpillPath: "b"}, false},
+		{&CaptureOptions{SpillToTemp: true}, &CaptureOptions{SpillToTemp: true}, false},
+		{&CaptureOptions{SpillPath: "a"}, &CaptureOptions{SpillPath: "./a"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := sharedSpillPath(tt.stdout, tt.stderr); got != tt.shared {
+			t.Errorf("sharedSpillPath(%v, %v) = %t, want %t", tt.stdout, tt.stderr, got, tt.shared)
+		}
+	}
+
+}
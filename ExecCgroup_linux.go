@@ -0,0 +1,246 @@
+// +build !js,linux
+
+package vutils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const cgroupCPUPeriod = 100000
+
+var transientCgroupCount uint64
+
+type transientCgroup struct {
+	path string
+	fd   int
+}
+
+func createTransientCgroup(limits *CgroupLimits) (*transientCgroup, error) {
+
+	//the cgroup of this process holds processes so no controllers can be enabled for its children
+	if limits.Parent == "" {
+		return nil, errors.New("Unable to create a transient cgroup without a Parent, it must be an empty cgroup delegated to this process")
+	}
+
+	mount, err := cgroup2Mount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	parentPath := limits.Parent
+
+	if parentPath != mount && !strings.HasPrefix(parentPath, mount+"/") {
+		parentPath = filepath.Join(mount, parentPath)
+	}
+
+	controllers := []string{}
+
+	if limits.MemoryMax > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if limits.CPUMax > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if limits.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+	}
+
+	if err := enableCgroupControllers(parentPath, controllers); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("vutils-%d-%d", os.Getpid(), atomic.AddUint64(&transientCgroupCount, 1))
+
+	cg := &transientCgroup{
+		path: filepath.Join(parentPath, name),
+		fd:   -1,
+	}
+
+	if err := os.Mkdir(cg.path, 0755); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to create cgroup %s: %s", cg.path, err.Error()))
+	}
+
+	settings := map[string]string{}
+
+	if limits.MemoryMax > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.MemoryMax, 10)
+	}
+	if limits.CPUMax > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPUMax*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+	if limits.PidsMax > 0 {
+		settings["pids.max"] = strconv.FormatInt(limits.PidsMax, 10)
+	}
+
+	for file, value := range settings {
+		if err := ioutil.WriteFile(filepath.Join(cg.path, file), []byte(value), 0644); err != nil {
+			cg.remove()
+			return nil, errors.New(fmt.Sprintf("Unable to set %s of cgroup %s: %s", file, cg.path, err.Error()))
+		}
+	}
+
+	return cg, nil
+
+}
+
+// attach has the command cloned straight into the cgroup so nothing it does escapes the limits
+func (cg *transientCgroup) attach(cmd *exec.Cmd) error {
+
+	fd, err := syscall.Open(cg.path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to open cgroup %s: %s", cg.path, err.Error()))
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+
+	cg.fd = fd
+
+	return nil
+
+}
+
+func (cg *transientCgroup) started() {
+	if cg.fd >= 0 {
+		syscall.Close(cg.fd)
+		cg.fd = -1
+	}
+}
+
+func (cg *transientCgroup) result() *ResourceResult {
+
+	rr := &ResourceResult{
+		Cgroup: cg.path,
+	}
+
+	if events, err := readKeyedFile(filepath.Join(cg.path, "memory.events")); err == nil {
+		rr.OOMKills, _ = strconv.Atoi(events["oom_kill"])
+	}
+
+	if peak, err := ioutil.ReadFile(filepath.Join(cg.path, "memory.peak")); err == nil {
+		rr.MemoryPeak, _ = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	}
+
+	return rr
+
+}
+
+func (cg *transientCgroup) remove() error {
+
+	cg.started()
+
+	err := os.Remove(cg.path)
+
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	//anything the command left running keeps the group busy, kill it and give the kernel a moment
+	ioutil.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0644)
+
+	for i := 0; i < 50 && err != nil && !os.IsNotExist(err); i++ {
+		time.Sleep(20 * time.Millisecond)
+		err = os.Remove(cg.path)
+	}
+
+	return err
+
+}
+
+func enableCgroupControllers(parentPath string, controllers []string) error {
+
+	if len(controllers) == 0 {
+		return nil
+	}
+
+	available, err := ioutil.ReadFile(filepath.Join(parentPath, "cgroup.controllers"))
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to read the controllers of cgroup %s: %s", parentPath, err.Error()))
+	}
+
+	enabled, err := ioutil.ReadFile(filepath.Join(parentPath, "cgroup.subtree_control"))
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to read the enabled controllers of cgroup %s: %s", parentPath, err.Error()))
+	}
+
+	for _, controller := range controllers {
+		if !containsString(strings.Fields(string(available)), controller) {
+			return errors.New(fmt.Sprintf("The %s controller is not available in cgroup %s", controller, parentPath))
+		} else if containsString(strings.Fields(string(enabled)), controller) {
+			continue
+		}
+		err := ioutil.WriteFile(filepath.Join(parentPath, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+		if err != nil && errors.Is(err, syscall.EBUSY) {
+			return errors.New(fmt.Sprintf("Unable to enable the %s controller in cgroup %s as it contains processes, use an empty delegated parent cgroup", controller, parentPath))
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("Unable to enable the %s controller in cgroup %s: %s", controller, parentPath, err.Error()))
+		}
+	}
+
+	return nil
+
+}
+
+func cgroup2Mount() (string, error) {
+
+	f, err := os.Open("/proc/self/mountinfo")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		//36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - cgroup2 cgroup2 rw
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) == 2 && len(fields) >= 5 && strings.HasPrefix(parts[1], "cgroup2 ") {
+			return fields[4], nil
+		}
+	}
+
+	return "", errors.New("cgroup v2 is not mounted")
+
+}
+
+func readKeyedFile(path string) (map[string]string, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			values[fields[0]] = fields[1]
+		}
+	}
+
+	return values, nil
+
+}
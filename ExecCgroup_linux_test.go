@@ -0,0 +1,67 @@
+// +build !js,linux
+
+package vutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCgroupLimitsRequireParent(t *testing.T) {
+
+	ec := Exec.CreateAsyncCommand("/bin/true", false).SetCgroupLimits(&CgroupLimits{MemoryMax: 64 << 20})
+
+	if err := ec.StartAndWait(); err == nil || !strings.Contains(err.Error(), "without a Parent") {
+		t.Errorf("StartAndWait returned %v, want an error about the missing Parent", err)
+	}
+
+}
+
+func TestEnableCgroupControllers(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		available   string
+		enabled     string
+		controllers []string
+		want        string
+		err         string
+	}{
+		{name: "nothing to enable", available: "cpu memory", enabled: "", want: ""},
+		{name: "already enabled", available: "cpu memory", enabled: "memory", controllers: []string{"memory"}, want: "memory"},
+		{name: "enabled", available: "cpu memory pids", enabled: "", controllers: []string{"pids"}, want: "+pids"},
+		{name: "not available", available: "cpu", enabled: "", controllers: []string{"memory"}, err: "The memory controller is not available"},
+	}
+
+	for _, tt := range tests {
+
+		dir, err := ioutil.TempDir("", "vutils-cgroup")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ioutil.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte(tt.available+"\n"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(tt.enabled), 0644)
+
+		err = enableCgroupControllers(dir, tt.controllers)
+		subtree, _ := ioutil.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+
+		os.RemoveAll(dir)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: enableCgroupControllers returned %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: enableCgroupControllers returned %s", tt.name, err)
+		} else if string(subtree) != tt.want {
+			t.Errorf("%s: cgroup.subtree_control = %q, want %q", tt.name, subtree, tt.want)
+		}
+
+	}
+
+}
@@ -0,0 +1,30 @@
+// +build !js,!linux
+
+package vutils
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type transientCgroup struct {
+	path string
+}
+
+func createTransientCgroup(limits *CgroupLimits) (*transientCgroup, error) {
+	return nil, errors.New("cgroup limits are only supported on Linux")
+}
+
+func (cg *transientCgroup) attach(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (cg *transientCgroup) started() {}
+
+func (cg *transientCgroup) result() *ResourceResult {
+	return &ResourceResult{}
+}
+
+func (cg *transientCgroup) remove() error {
+	return nil
+}
@@ -0,0 +1,391 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DaemonOptions configure Daemonize, only PidFile is required.
+type DaemonOptions struct {
+	PidFile string
+	//StdoutLog and StderrLog are appended to, output is discarded when they are empty
+	StdoutLog string
+	StderrLog string
+	//StartupCheck makes Daemonize wait this long and fail if the daemon has already exited
+	StartupCheck time.Duration
+}
+
+type DaemonStatus struct {
+	PidFile string
+	Pid     int
+	Running bool
+	//Stale is set when the pidfile exists but the daemon it names is gone
+	Stale bool
+}
+
+// StopOptions control how StopDaemon and the ProcessManager escalate, the zero value sends SIGTERM (SIGINT for the
+// ProcessManager), waits 10 seconds and then sends SIGKILL.
+type StopOptions struct {
+	//Signals are sent in order, each followed by waiting up to Timeout for the process to exit
+	Signals []os.Signal
+	Timeout time.Duration
+	//Timeouts replace Timeout for the signal at the same index, signals past their end use Timeout or, when it is not
+	//set, the last of them
+	Timeouts []time.Duration
+	//NoKill skips the final SIGKILL
+	NoKill bool
+}
+
+const defaultStopTimeout = 10 * time.Second
+
+// steps returns the signals to send in order, ending in SIGKILL unless NoKill is set, and how long to wait after each
+func (so *StopOptions) steps(defaultSignal os.Signal) ([]os.Signal, []time.Duration) {
+
+	signals := so.Signals
+
+	if len(signals) == 0 {
+		signals = []os.Signal{defaultSignal}
+	}
+
+	if !so.NoKill {
+		signals = append(append([]os.Signal{}, signals...), os.Kill)
+	}
+
+	timeouts := make([]time.Duration, len(signals))
+
+	for i := range signals {
+		timeouts[i] = so.Timeout
+		if i < len(so.Timeouts) {
+			timeouts[i] = so.Timeouts[i]
+		} else if len(so.Timeouts) > 0 && so.Timeout <= 0 {
+			timeouts[i] = so.Timeouts[len(so.Timeouts)-1]
+		}
+		if timeouts[i] <= 0 {
+			timeouts[i] = defaultStopTimeout
+		}
+	}
+
+	return signals, timeouts
+
+}
+
+// pidFileWatcherScript holds the pidfile lock it inherits as fd 3 until the daemon named by $1 has exited, the daemon
+// itself never sees the lock so closing its files or leaving children behind cannot release or keep it
+const pidFileWatcherScript = `while kill -0 "$1" 2>/dev/null; do sleep 1; done`
+
+// Daemonize starts the command detached from this process in a new session, with STDIN from /dev/null and its output
+// appended to log files. The pidfile is locked for as long as the daemon runs, starting a second daemon with the
+// same pidfile fails while the first is running and a stale pidfile is reused. The lock is held by a small watcher
+// process rather than the daemon. The daemon is reaped in the background while this process is running.
+//
+// The command cannot have its STDIO bound or captured, run on a PTY, in a cgroup, in a new PID namespace or with a
+// retry policy.
+func (ec *ExecAsyncCommand) Daemonize(options *DaemonOptions) (*DaemonStatus, error) {
+
+	if err := checkDaemonSupport(); err != nil {
+		return nil, err
+	} else if options == nil || options.PidFile == "" {
+		return nil, errors.New("Unable to daemonize without a pidfile")
+	} else if ec.stdioBound || ec.stdioCapture || ec.pty {
+		return nil, errors.New("Unable to daemonize a command with bound or captured STDIO or a PTY")
+	} else if ec.resources != nil && ec.resources.Cgroup != nil {
+		return nil, errors.New("Unable to daemonize a command in a transient cgroup as it is removed when the command exits")
+	} else if ec.namespaces != nil && ec.namespaces.PID {
+		return nil, errors.New("Unable to daemonize a command in a new PID namespace")
+	} else if ec.retry != nil {
+		return nil, errors.New("Unable to daemonize a command with a retry policy")
+	}
+
+	if Exec.IsDryRun() {
+		return &DaemonStatus{PidFile: options.PidFile}, ec.StartAndWait()
+	}
+
+	pidFile, err := os.OpenFile(options.PidFile, os.O_RDWR|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer pidFile.Close()
+
+	if err := lockPidFile(pidFile); err != nil {
+		if pid, _ := readPidFile(pidFile); pid > 0 {
+			return nil, errors.New(fmt.Sprintf("Daemon is already running with pid %d (%s)", pid, options.PidFile))
+		}
+		return nil, errors.New(fmt.Sprintf("Unable to lock pidfile %s: %s", options.PidFile, err.Error()))
+	}
+
+	started := false
+
+	//we hold the lock so the pidfile is ours to remove when the daemon does not start
+	defer func() {
+		if !started {
+			os.Remove(options.PidFile)
+		}
+	}()
+
+	if err := pidFile.Truncate(0); err != nil {
+		return nil, err
+	}
+
+	devNull, err := os.Open(os.DevNull)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := openDaemonLog(options.StdoutLog)
+
+	if err != nil {
+		devNull.Close()
+		return nil, err
+	}
+
+	stderr := stdout
+
+	if options.StderrLog != options.StdoutLog {
+		if stderr, err = openDaemonLog(options.StderrLog); err != nil {
+			devNull.Close()
+			stdout.Close()
+			return nil, err
+		}
+	}
+
+	//the files are closed on our side once the daemon has started
+	ec.redirectStdin(devNull, devNull)
+	ec.redirectStdout(stdout, stdout)
+	if stderr != stdout {
+		ec.redirectStderr(stderr, stderr)
+	} else {
+		ec.redirectStderr(stderr, nil)
+	}
+
+	ec.prepareProc()
+
+	if ec.Proc.SysProcAttr == nil {
+		ec.Proc.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	//the daemon is exec'd straight into a new session, it keeps the default signal handling a background job of a
+	//shell would lose
+	setDaemonSession(ec.Proc.SysProcAttr)
+
+	logInfo("Starting daemon", LogF("command", ec.path), LogF("args", strings.Join(ec.args, ` `)), LogF("pidfile", options.PidFile))
+
+	if err := ec.startProc(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to start daemon: %s", err.Error()))
+	}
+
+	pid := ec.Pid()
+
+	go ec.Wait()
+
+	if err := writePidFile(pidFile, pid); err != nil {
+		ec.Signal(os.Kill)
+		return nil, errors.New(fmt.Sprintf("Unable to write the pid of the daemon: %s", err.Error()))
+	}
+
+	if err := watchPidFile(pidFile, pid); err != nil {
+		ec.Signal(os.Kill)
+		return nil, errors.New(fmt.Sprintf("Unable to start the pidfile watcher: %s", err.Error()))
+	}
+
+	status := &DaemonStatus{
+		PidFile: options.PidFile,
+		Pid:     pid,
+		Running: true,
+	}
+
+	if options.StartupCheck > 0 {
+		time.Sleep(options.StartupCheck)
+		if !processAlive(pid) {
+			msg := fmt.Sprintf("Daemon exited within %s of starting", options.StartupCheck)
+			if options.StderrLog != "" {
+				msg += ", see " + options.StderrLog
+			}
+			return nil, errors.New(msg)
+		}
+	}
+
+	logInfo("Started daemon", LogF("command", ec.path), LogF("pid", pid))
+
+	started = true
+
+	return status, nil
+
+}
+
+func openDaemonLog(path string) (*os.File, error) {
+	if path == "" {
+		return os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+func writePidFile(f *os.File, pid int) error {
+
+	if _, err := f.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0); err != nil {
+		return err
+	}
+
+	return f.Sync()
+
+}
+
+// watchPidFile hands the locked pidfile to a watcher in a session of its own that keeps it locked while pid runs
+func watchPidFile(f *os.File, pid int) error {
+
+	watcher := exec.Command("/bin/sh", "-c", pidFileWatcherScript, "sh", strconv.Itoa(pid))
+	watcher.ExtraFiles = []*os.File{f}
+	watcher.SysProcAttr = &syscall.SysProcAttr{}
+
+	setDaemonSession(watcher.SysProcAttr)
+
+	if err := watcher.Start(); err != nil {
+		return err
+	}
+
+	go watcher.Wait()
+
+	return nil
+
+}
+
+func readPidFile(f *os.File) (int, error) {
+
+	buf := make([]byte, 32)
+	n, err := f.ReadAt(buf, 0)
+
+	if n == 0 && err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+
+}
+
+// DaemonStatus reports whether the daemon named by pidFile is running. It is running while its pidfile is locked and
+// its process exists, a pidfile that is left over is reported as stale.
+func (ex *execUtils) DaemonStatus(pidFile string) (*DaemonStatus, error) {
+
+	status := &DaemonStatus{
+		PidFile: pidFile,
+	}
+
+	f, err := os.OpenFile(pidFile, os.O_RDWR, 0)
+
+	if os.IsNotExist(err) {
+		return status, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	status.Pid, _ = readPidFile(f)
+
+	locked, err := pidFileLocked(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	status.Running = locked && status.Pid > 0 && processAlive(status.Pid)
+	status.Stale = !status.Running
+
+	return status, nil
+
+}
+
+// StopDaemon signals the daemon named by pidFile and its process group, escalating as set in options (nil for the
+// defaults), and removes its pidfile once the daemon and the processes it started have exited. Stopping a daemon that is not running only cleans up a stale pidfile.
+func (ex *execUtils) StopDaemon(pidFile string, options *StopOptions) error {
+
+	status, err := ex.DaemonStatus(pidFile)
+
+	if err != nil {
+		return err
+	} else if !status.Running {
+		_, err := ex.CleanStalePidFile(pidFile)
+		return err
+	}
+
+	if options == nil {
+		options = &StopOptions{}
+	}
+
+	signals, timeouts := options.steps(syscall.SIGTERM)
+
+	//the daemon runs in its own session, signalling its process group stops the children it started as well
+	pgid := daemonProcessGroup(status.Pid)
+
+	for i, sig := range signals {
+
+		logInfo("Stopping daemon", LogF("pid", status.Pid), LogF("signal", sig.String()), LogF("pgid", pgid))
+
+		if err := signalDaemon(status.Pid, pgid, sig); err != nil && daemonAlive(status.Pid, pgid) {
+			return errors.New(fmt.Sprintf("Unable to signal daemon %d: %s", status.Pid, err.Error()))
+		}
+
+		deadline := time.Now().Add(timeouts[i])
+
+		for daemonAlive(status.Pid, pgid) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		if !daemonAlive(status.Pid, pgid) {
+			if err := os.Remove(pidFile); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+
+	}
+
+	if pgid > 0 && !processAlive(status.Pid) {
+		return errors.New(fmt.Sprintf("Processes started by daemon %d are still running after %s", status.Pid, signals[len(signals)-1].String()))
+	}
+
+	return errors.New(fmt.Sprintf("Daemon %d is still running after %s", status.Pid, signals[len(signals)-1].String()))
+
+}
+
+// CleanStalePidFile removes pidFile if the daemon it names is no longer running, reporting whether it did.
+func (ex *execUtils) CleanStalePidFile(pidFile string) (bool, error) {
+
+	status, err := ex.DaemonStatus(pidFile)
+
+	if err != nil || !status.Stale {
+		return false, err
+	}
+
+	//take the lock so a daemon starting right now does not lose its pidfile
+	f, err := os.OpenFile(pidFile, os.O_RDWR, 0)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	if err := lockPidFile(f); err != nil {
+		return false, nil
+	}
+
+	if pid, _ := readPidFile(f); pid != status.Pid {
+		return false, nil
+	}
+
+	logInfo("Removing stale pidfile", LogF("pidfile", pidFile), LogF("pid", status.Pid))
+
+	return true, os.Remove(pidFile)
+
+}
@@ -0,0 +1,96 @@
+// +build !js
+
+package vutils
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStopOptionsSteps(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		options  *StopOptions
+		signals  []os.Signal
+		timeouts []time.Duration
+	}{
+		{
+			name:     "zero value",
+			options:  &StopOptions{},
+			signals:  []os.Signal{syscall.SIGTERM, os.Kill},
+			timeouts: []time.Duration{defaultStopTimeout, defaultStopTimeout},
+		},
+		{
+			name:     "no kill",
+			options:  &StopOptions{NoKill: true, Timeout: time.Second},
+			signals:  []os.Signal{syscall.SIGTERM},
+			timeouts: []time.Duration{time.Second},
+		},
+		{
+			name:     "signals with one timeout",
+			options:  &StopOptions{Signals: []os.Signal{syscall.SIGINT, syscall.SIGTERM}, Timeout: 3 * time.Second},
+			signals:  []os.Signal{syscall.SIGINT, syscall.SIGTERM, os.Kill},
+			timeouts: []time.Duration{3 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name: "timeouts fall back to timeout",
+			options: &StopOptions{
+				Signals:  []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+				Timeout:  time.Second,
+				Timeouts: []time.Duration{5 * time.Second},
+			},
+			signals:  []os.Signal{syscall.SIGINT, syscall.SIGTERM, os.Kill},
+			timeouts: []time.Duration{5 * time.Second, time.Second, time.Second},
+		},
+		{
+			name: "timeouts fall back to the last of them",
+			options: &StopOptions{
+				Signals:  []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+				Timeouts: []time.Duration{5 * time.Second, 2 * time.Second},
+			},
+			signals:  []os.Signal{syscall.SIGINT, syscall.SIGTERM, os.Kill},
+			timeouts: []time.Duration{5 * time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:     "zero timeouts use the default",
+			options:  &StopOptions{Signals: []os.Signal{syscall.SIGHUP}, Timeouts: []time.Duration{0, time.Second}},
+			signals:  []os.Signal{syscall.SIGHUP, os.Kill},
+			timeouts: []time.Duration{defaultStopTimeout, time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+
+		signals, timeouts := tt.options.steps(syscall.SIGTERM)
+
+		if len(signals) != len(tt.signals) || len(timeouts) != len(tt.timeouts) {
+			t.Errorf("%s: steps = %v %v, want %v %v", tt.name, signals, timeouts, tt.signals, tt.timeouts)
+			continue
+		}
+
+		for i := range signals {
+			if signals[i] != tt.signals[i] || timeouts[i] != tt.timeouts[i] {
+				t.Errorf("%s: steps = %v %v, want %v %v", tt.name, signals, timeouts, tt.signals, tt.timeouts)
+				break
+			}
+		}
+
+	}
+
+}
+
+func TestStopOptionsStepsKeepsSignals(t *testing.T) {
+
+	options := &StopOptions{Signals: make([]os.Signal, 1, 4)}
+	options.Signals[0] = syscall.SIGINT
+
+	options.steps(syscall.SIGTERM)
+
+	if len(options.Signals) != 1 || options.Signals[:2][1] != nil {
+		t.Errorf("steps modified the signals of the options: %v", options.Signals[:2])
+	}
+
+}
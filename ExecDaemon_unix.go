@@ -0,0 +1,137 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func checkDaemonSupport() error {
+	return nil
+}
+
+func setDaemonSession(attr *syscall.SysProcAttr) {
+	attr.Setsid = true
+}
+
+func lockPidFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// pidFileLocked reports whether another open file holds the lock on f
+func pidFileLocked(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// processAlive reports whether pid exists and is not a zombie waiting for its parent (which for a daemon is init) to
+// reap it
+func processAlive(pid int) bool {
+
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	//the state follows the command name in parentheses, which can itself contain spaces and parentheses
+	if stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		if i := bytes.LastIndexByte(stat, ')'); i > 0 && i+2 < len(stat) && stat[i+2] == 'Z' {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// daemonProcessGroup returns the process group of the daemon, which Daemonize starts in a session of its own, or 0
+// when it shares the group of this process
+func daemonProcessGroup(pid int) int {
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil || pgid <= 1 || pgid == syscall.Getpgrp() {
+		return 0
+	}
+	return pgid
+}
+
+// signalDaemon signals the daemon, or its whole process group when it has one
+func signalDaemon(pid int, pgid int, sig os.Signal) error {
+
+	s, ok := sig.(syscall.Signal)
+
+	if !ok {
+		return errors.New(fmt.Sprintf("Unable to send %s to a process", sig.String()))
+	}
+
+	if pgid > 0 {
+		return syscall.Kill(-pgid, s)
+	}
+
+	return syscall.Kill(pid, s)
+
+}
+
+// daemonAlive reports whether the daemon is running, or when it has a process group whether any process of it is
+func daemonAlive(pid int, pgid int) bool {
+
+	if pgid <= 0 {
+		return processAlive(pid)
+	}
+
+	if err := syscall.Kill(-pgid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	//without /proc zombies left in the group count as running
+	entries, err := ioutil.ReadDir("/proc")
+
+	if err != nil {
+		return true
+	}
+
+	for _, entry := range entries {
+
+		member, err := strconv.Atoi(entry.Name())
+
+		if err != nil {
+			continue
+		}
+
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", member))
+
+		if err != nil {
+			continue
+		}
+
+		//after the command name come the state, the parent pid and the process group
+		i := bytes.LastIndexByte(stat, ')')
+
+		if i < 0 {
+			continue
+		}
+
+		fields := bytes.Fields(stat[i+1:])
+
+		if len(fields) < 3 || string(fields[0]) == "Z" {
+			continue
+		}
+
+		if group, err := strconv.Atoi(string(fields[2])); err == nil && group == pgid {
+			return true
+		}
+
+	}
+
+	return false
+
+}
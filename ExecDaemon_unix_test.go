@@ -0,0 +1,159 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDaemonize(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-daemon")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "daemon.pid")
+	logFile := filepath.Join(dir, "daemon.log")
+
+	//the daemon closes every file it did not expect, as many daemons do, and must still be seen as running
+	script := `echo started; for fd in 3 4 5 6 7 8 9; do eval "exec $fd>&-"; done; exec sleep 30`
+
+	status, err := Exec.CreateAsyncCommand("/bin/sh", false, "-c", script).Daemonize(&DaemonOptions{
+		PidFile:      pidFile,
+		StdoutLog:    logFile,
+		StartupCheck: 100 * time.Millisecond,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer syscall.Kill(status.Pid, syscall.SIGKILL)
+
+	if pgid, _ := syscall.Getpgid(status.Pid); pgid != status.Pid {
+		t.Errorf("the daemon is in process group %d, not a session of its own", pgid)
+	}
+
+	if running, err := Exec.DaemonStatus(pidFile); err != nil || !running.Running || running.Stale || running.Pid != status.Pid {
+		t.Errorf("DaemonStatus = %+v, %v", running, err)
+	}
+
+	if fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", status.Pid)); err == nil {
+		for _, fd := range fds {
+			if target, _ := os.Readlink(fmt.Sprintf("/proc/%d/fd/%s", status.Pid, fd.Name())); target == pidFile {
+				t.Errorf("the daemon has the pidfile open as fd %s", fd.Name())
+			}
+		}
+	}
+
+	if _, err := Exec.CreateAsyncCommand("/bin/sleep", false, "30").Daemonize(&DaemonOptions{PidFile: pidFile}); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("a second daemon with the same pidfile returned %v", err)
+	}
+
+	if data, _ := ioutil.ReadFile(logFile); string(data) != "started\n" {
+		t.Errorf("the log holds %q", data)
+	}
+
+	//a background job of a non-interactive shell would ignore SIGINT
+	if err := Exec.StopDaemon(pidFile, &StopOptions{Signals: []os.Signal{syscall.SIGINT}, Timeout: 5 * time.Second, NoKill: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("StopDaemon left the pidfile behind: %v", err)
+	} else if stopped, err := Exec.DaemonStatus(pidFile); err != nil || stopped.Running || stopped.Stale {
+		t.Errorf("DaemonStatus after stopping = %+v, %v", stopped, err)
+	}
+
+}
+
+func TestDaemonizeStartupCheck(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-daemon")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "daemon.pid")
+	logFile := filepath.Join(dir, "daemon.err")
+
+	_, err = Exec.CreateAsyncCommand("/bin/sh", false, "-c", "echo failed >&2; exit 1").Daemonize(&DaemonOptions{
+		PidFile:      pidFile,
+		StderrLog:    logFile,
+		StartupCheck: 300 * time.Millisecond,
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "exited within 300ms of starting, see "+logFile) {
+		t.Errorf("Daemonize returned %v", err)
+	} else if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("the pidfile of a daemon that did not start was kept: %v", err)
+	} else if data, _ := ioutil.ReadFile(logFile); string(data) != "failed\n" {
+		t.Errorf("the log holds %q", data)
+	}
+
+	tests := []struct {
+		ec   *ExecAsyncCommand
+		opts *DaemonOptions
+		want string
+	}{
+		{Exec.CreateAsyncCommand("/bin/true", false), &DaemonOptions{}, "without a pidfile"},
+		{Exec.CreateAsyncCommand("/bin/true", false).CaptureStdoutAndStdErr(false, false), &DaemonOptions{PidFile: pidFile}, "bound or captured STDIO"},
+		{Exec.CreateAsyncCommand("/bin/true", false).Retry(NewRetryPolicy(2)), &DaemonOptions{PidFile: pidFile}, "retry policy"},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.ec.Daemonize(tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Daemonize returned %v, want %q", err, tt.want)
+		}
+	}
+
+}
+
+func TestCleanStalePidFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-daemon")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	exited := Exec.CreateAsyncCommand("/bin/true", false)
+
+	if err := exited.StartAndWait(); err != nil {
+		t.Fatal(err)
+	}
+
+	pidFile := filepath.Join(dir, "stale.pid")
+	ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", exited.Pid())), 0644)
+
+	if status, err := Exec.DaemonStatus(pidFile); err != nil || status.Running || !status.Stale {
+		t.Errorf("DaemonStatus of a stale pidfile = %+v, %v", status, err)
+	}
+
+	if removed, err := Exec.CleanStalePidFile(pidFile); err != nil || !removed {
+		t.Errorf("CleanStalePidFile returned %t, %v", removed, err)
+	} else if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Error("the stale pidfile was not removed")
+	}
+
+	if err := Exec.StopDaemon(pidFile, nil); err != nil {
+		t.Errorf("StopDaemon of a daemon that is not running returned %v", err)
+	}
+
+}
@@ -0,0 +1,43 @@
+// +build !js,windows
+
+package vutils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func checkDaemonSupport() error {
+	return errors.New("Daemonize is not supported on Windows")
+}
+
+func setDaemonSession(attr *syscall.SysProcAttr) {}
+
+func lockPidFile(f *os.File) error {
+	return checkDaemonSupport()
+}
+
+func pidFileLocked(f *os.File) (bool, error) {
+	return false, checkDaemonSupport()
+}
+
+func processAlive(pid int) bool {
+	return false
+}
+
+func daemonProcessGroup(pid int) int {
+	return 0
+}
+
+func signalDaemon(pid int, pgid int, sig os.Signal) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return proc.Signal(sig)
+}
+
+func daemonAlive(pid int, pgid int) bool {
+	return processAlive(pid)
+}
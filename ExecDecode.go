@@ -0,0 +1,293 @@
+// +build !js
+
+package vutils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// DecodeError is returned by the Decode helpers when the command fails or its output cannot be decoded, it carries
+// what the command wrote to STDERR last and its exit code.
+type DecodeError struct {
+	Format string
+	//Line of the output the error was found on, 0 when it does not apply
+	Line     int
+	ExitCode int
+	Stderr   []byte
+	Err      error
+	//failed is set when the command failed and its output was never decoded
+	failed bool
+}
+
+func (de *DecodeError) Error() string {
+
+	msg := fmt.Sprintf("Unable to decode %s output: %s", de.Format, de.Err.Error())
+
+	if de.failed {
+		msg = fmt.Sprintf("Command failed before its %s output was decoded: %s", de.Format, de.Err.Error())
+	} else if de.Line > 0 {
+		msg = fmt.Sprintf("Unable to decode %s output on line %d: %s", de.Format, de.Line, de.Err.Error())
+	}
+
+	//the last few lines of STDERR are usually what explains the failure
+	if stderr := bytes.TrimSpace(de.Stderr); len(stderr) > 0 {
+		if len(stderr) > 512 {
+			stderr = stderr[len(stderr)-512:]
+		}
+		msg += ": " + string(stderr)
+	}
+
+	return msg
+
+}
+
+func (de *DecodeError) Unwrap() error {
+	return de.Err
+}
+
+func (ec *ExecAsyncCommand) decodeError(format string, line int, err error) *DecodeError {
+	return &DecodeError{
+		Format:   format,
+		Line:     line,
+		ExitCode: ec.ExitCode(),
+		Stderr:   ec.stderrTail.Bytes(),
+		Err:      err,
+	}
+}
+
+// runForDecode runs the command to completion capturing its output, retry policies are honoured
+func (ec *ExecAsyncCommand) runForDecode(format string) ([]byte, error) {
+
+	if ec.stdioBound {
+		return nil, errors.New("Unable to decode output as STDIO is bound")
+	} else if ec.errOnly {
+		return nil, errors.New("Unable to decode output of a command created with errOnly")
+	}
+
+	ec.CaptureStdoutAndStdErr(false, false)
+
+	if err := ec.StartAndWait(); err != nil {
+		de := ec.decodeError(format, 0, err)
+		de.failed = true
+		return nil, de
+	}
+
+	return ec.GetStdoutBuffer(), nil
+
+}
+
+// DecodeJSON runs the command and unmarshals its STDOUT into v. Bounded capture (CaptureWithOptions) must keep the
+// whole output for this to work.
+func (ec *ExecAsyncCommand) DecodeJSON(v interface{}) error {
+
+	out, err := ec.runForDecode("JSON")
+
+	if err != nil {
+		return err
+	} else if err := json.Unmarshal(out, v); err != nil {
+		return ec.decodeError("JSON", 0, err)
+	}
+
+	return nil
+
+}
+
+// DecodeJSONLines runs the command and calls fn with every JSON document on a line of its STDOUT as it is written,
+// blank lines are skipped. When fn returns an error the command is killed and that error is returned. The command
+// cannot have its output bound or captured, or a retry policy.
+func (ec *ExecAsyncCommand) DecodeJSONLines(fn func(record json.RawMessage) error) error {
+
+	if ec.stdioBound || ec.stdioCapture {
+		return errors.New("Unable to decode output as STDIO is already bound or captured")
+	} else if ec.errOnly {
+		return errors.New("Unable to decode output of a command created with errOnly")
+	} else if ec.retry != nil {
+		return errors.New("Unable to stream the output of a command with a retry policy, use DecodeJSON")
+	}
+
+	//STDERR still has to be read so the command does not block on it, its tail is kept for errors
+	ec.attachOutput(func() {
+		errReader := ec.stderrSource()
+		ec.ioWait.Add(1)
+		go func() {
+			defer ec.ioWait.Done()
+			io.Copy(ioutil.Discard, errReader)
+		}()
+	})
+
+	ec.stdioCapture = true
+
+	reader := bufio.NewReader(ec.stdoutSource())
+
+	if err := ec.Start(); err != nil {
+		return err
+	}
+
+	var streamErr error
+	line := 0
+
+	for streamErr == nil {
+
+		data, err := reader.ReadBytes('\n')
+		line++
+
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+			if !json.Valid(trimmed) {
+				streamErr = ec.decodeError("JSON Lines", line, errors.New("invalid JSON"))
+			} else {
+				streamErr = fn(json.RawMessage(trimmed))
+			}
+		}
+
+		if err != nil {
+			break
+		}
+
+	}
+
+	if streamErr != nil {
+		//closing the pipes rather than draining them means children of the command still holding them do not block
+		ec.Signal(os.Kill)
+		ec.closeStdio()
+		ec.Wait()
+		return streamErr
+	}
+
+	if err := ec.Wait(); err != nil {
+		de := ec.decodeError("JSON Lines", 0, err)
+		de.failed = true
+		return de
+	}
+
+	return nil
+
+}
+
+// DecodeCSV runs the command and parses its STDOUT as CSV records separated by comma.
+func (ec *ExecAsyncCommand) DecodeCSV(comma rune) ([][]string, error) {
+	return ec.decodeDelimited("CSV", comma, false)
+}
+
+// DecodeTSV parses tab separated output, quotes are taken literally where they are not around a whole field.
+func (ec *ExecAsyncCommand) DecodeTSV() ([][]string, error) {
+	return ec.decodeDelimited("TSV", '\t', true)
+}
+
+// DecodeCSVRecords parses CSV (or TSV with a tab as comma) output whose first row is a header, every further row is
+// returned keyed by the header.
+func (ec *ExecAsyncCommand) DecodeCSVRecords(comma rune) ([]map[string]string, error) {
+
+	format := "CSV"
+
+	if comma == '\t' {
+		format = "TSV"
+	}
+
+	rows, err := ec.decodeDelimited(format, comma, comma == '\t')
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]string{}
+
+	if len(rows) == 0 {
+		return records, nil
+	}
+
+	header := rows[0]
+
+	for i, row := range rows[1:] {
+		if len(row) != len(header) {
+			return nil, ec.decodeError(format, i+2, errors.New(fmt.Sprintf("row has %d fields but the header has %d", len(row), len(header))))
+		}
+		record := make(map[string]string, len(header))
+		for f, name := range header {
+			record[name] = row[f]
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+
+}
+
+func (ec *ExecAsyncCommand) decodeDelimited(format string, comma rune, lazyQuotes bool) ([][]string, error) {
+
+	out, err := ec.runForDecode(format)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(out))
+	reader.Comma = comma
+	reader.LazyQuotes = lazyQuotes
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+
+	if err != nil {
+		line := 0
+		if pe, ok := err.(*csv.ParseError); ok {
+			line = pe.Line
+		}
+		return nil, ec.decodeError(format, line, err)
+	}
+
+	return rows, nil
+
+}
+
+// DecodeKeyValue runs the command and parses KEY=value lines (os-release, systemctl show, env) from its STDOUT. Blank
+// lines and lines starting with # are skipped and values in single or double quotes are unquoted as sh would.
+func (ec *ExecAsyncCommand) DecodeKeyValue() (map[string]string, error) {
+
+	out, err := ec.runForDecode("key=value")
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+
+	for i, line := range strings.Split(string(out), "\n") {
+
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sep := strings.Index(line, "=")
+
+		if sep <= 0 {
+			return nil, ec.decodeError("key=value", i+1, errors.New(fmt.Sprintf("expected KEY=value but got %q", line)))
+		}
+
+		key, value := strings.TrimSpace(line[:sep]), line[sep+1:]
+
+		if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'") {
+			words, err := ShellSplit(value)
+			if err != nil {
+				return nil, ec.decodeError("key=value", i+1, err)
+			}
+			value = strings.Join(words, " ")
+		}
+
+		values[key] = value
+
+	}
+
+	return values, nil
+
+}
@@ -0,0 +1,173 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeJSON(t *testing.T) {
+
+	var v struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Count int      `json:"count"`
+	}
+
+	if err := shellStage(`echo '{"name": "vutils", "tags": ["a", "b"], "count": 2}'`).DecodeJSON(&v); err != nil {
+		t.Fatal(err)
+	} else if v.Name != "vutils" || !equalStrings(v.Tags, []string{"a", "b"}) || v.Count != 2 {
+		t.Errorf("decoded %+v", v)
+	}
+
+	err := shellStage(`echo '{"name": '`).DecodeJSON(&v)
+
+	if de, ok := err.(*DecodeError); !ok || de.failed || !strings.HasPrefix(err.Error(), "Unable to decode JSON output: ") {
+		t.Errorf("DecodeJSON of invalid JSON returned %v", err)
+	}
+
+	err = shellStage(`echo 'permission denied' >&2; exit 5`).DecodeJSON(&v)
+
+	if de, ok := err.(*DecodeError); !ok || de.ExitCode != 5 || !strings.HasSuffix(err.Error(), ": permission denied") || !strings.HasPrefix(err.Error(), "Command failed before its JSON output was decoded") {
+		t.Errorf("DecodeJSON of a failed command returned %v", err)
+	} else if de.Unwrap() != de.Err {
+		t.Error("Unwrap does not return the cause")
+	}
+
+	bound := shellStage("true").BindToStdoutAndStdErr()
+
+	if err := bound.DecodeJSON(&v); err == nil || err.Error() != "Unable to decode output as STDIO is bound" {
+		t.Errorf("DecodeJSON of a bound command returned %v", err)
+	}
+
+}
+
+func TestDecodeJSONLines(t *testing.T) {
+
+	names := []string{}
+
+	err := shellStage(`printf '{"n":"a"}\n\n{"n":"b"}\n{"n":"c"}'`).DecodeJSONLines(func(record json.RawMessage) error {
+		var v struct{ N string }
+		if err := json.Unmarshal(record, &v); err != nil {
+			return err
+		}
+		names = append(names, v.N)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	} else if !equalStrings(names, []string{"a", "b", "c"}) {
+		t.Errorf("records = %q", names)
+	}
+
+	err = shellStage(`echo '{}'; echo 'not json'; echo '{}'`).DecodeJSONLines(func(json.RawMessage) error { return nil })
+
+	if de, ok := err.(*DecodeError); !ok || de.Line != 2 || !strings.Contains(err.Error(), "on line 2: invalid JSON") {
+		t.Errorf("DecodeJSONLines of invalid JSON returned %v", err)
+	}
+
+	stop := errors.New("stop")
+	start := time.Now()
+
+	//the command would run forever, returning an error from fn kills it
+	err = shellStage(`while :; do echo '{}'; sleep 0.01; done`).DecodeJSONLines(func(json.RawMessage) error { return stop })
+
+	if err != stop {
+		t.Errorf("DecodeJSONLines returned %v, want the error of fn", err)
+	} else if time.Since(start) > 4*time.Second {
+		t.Error("the command was not killed")
+	}
+
+	err = shellStage(`echo '{}'; exit 3`).DecodeJSONLines(func(json.RawMessage) error { return nil })
+
+	if de, ok := err.(*DecodeError); !ok || de.ExitCode != 3 || !de.failed {
+		t.Errorf("DecodeJSONLines of a failed command returned %v", err)
+	}
+
+	for _, ec := range []*ExecAsyncCommand{
+		shellStage("true").CaptureStdoutAndStdErr(false, false),
+		shellStage("true").Retry(NewRetryPolicy(2)),
+	} {
+		if err := ec.DecodeJSONLines(func(json.RawMessage) error { return nil }); err == nil {
+			t.Error("DecodeJSONLines accepted a command it cannot stream")
+		}
+	}
+
+}
+
+func TestDecodeDelimited(t *testing.T) {
+
+	rows, err := shellStage(`printf 'a,b\n"c,d",e\n'`).DecodeCSV(',')
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 2 || !equalStrings(rows[1], []string{"c,d", "e"}) {
+		t.Errorf("DecodeCSV = %q", rows)
+	}
+
+	rows, err = shellStage(`printf 'name\tsize\n5" disk\t10\n'`).DecodeTSV()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 2 || !equalStrings(rows[1], []string{`5" disk`, "10"}) {
+		t.Errorf("DecodeTSV = %q", rows)
+	}
+
+	records, err := shellStage(`printf 'name;size\nroot;10\nhome;20\n'`).DecodeCSVRecords(';')
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 2 || records[1]["name"] != "home" || records[1]["size"] != "20" {
+		t.Errorf("DecodeCSVRecords = %v", records)
+	}
+
+	if records, err := shellStage("true").DecodeCSVRecords(','); err != nil || len(records) != 0 {
+		t.Errorf("DecodeCSVRecords of no output = %v, %v", records, err)
+	}
+
+	_, err = shellStage(`printf 'a\tb\nc\n'`).DecodeCSVRecords('\t')
+
+	if de, ok := err.(*DecodeError); !ok || de.Format != "TSV" || de.Line != 2 {
+		t.Errorf("DecodeCSVRecords of a short row returned %v", err)
+	}
+
+	_, err = shellStage(`printf 'a,b\n"c,d\n'`).DecodeCSV(',')
+
+	if de, ok := err.(*DecodeError); !ok || de.Line != 2 {
+		t.Errorf("DecodeCSV of an unterminated quote returned %v", err)
+	}
+
+}
+
+func TestDecodeKeyValue(t *testing.T) {
+
+	values, err := shellStage(`printf '# os-release\nNAME="Debian GNU/Linux"\n\nID=debian\nVERSION_CODENAME='"'"'book worm'"'"'\nEMPTY=\n'`).DecodeKeyValue()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"NAME": "Debian GNU/Linux", "ID": "debian", "VERSION_CODENAME": "book worm", "EMPTY": ""}
+
+	if len(values) != len(want) {
+		t.Errorf("DecodeKeyValue = %q, want %q", values, want)
+	}
+
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %q, want %q", key, values[key], value)
+		}
+	}
+
+	_, err = shellStage(`printf 'A=1\nnot a pair\n'`).DecodeKeyValue()
+
+	if de, ok := err.(*DecodeError); !ok || de.Line != 2 || de.Format != "key=value" {
+		t.Errorf("DecodeKeyValue of a line without = returned %v", err)
+	}
+
+}
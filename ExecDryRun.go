@@ -0,0 +1,209 @@
+// +build !js
+
+package vutils
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PlannedCommand struct {
+	Path       string            `json:"path"`
+	Args       []string          `json:"args"`
+	Dir        string            `json:"dir,omitempty"`
+	EnvAdded   map[string]string `json:"envAdded,omitempty"`
+	EnvChanged map[string]string `json:"envChanged,omitempty"`
+	EnvRemoved []string          `json:"envRemoved,omitempty"`
+	Sudo       bool              `json:"sudo"`
+	Privilege  string            `json:"privilege,omitempty"`
+	Time       time.Time         `json:"time"`
+}
+
+type ExecPlan struct {
+	lock     sync.Mutex
+	commands []*PlannedCommand
+}
+
+func (ep *ExecPlan) add(pc *PlannedCommand) {
+	ep.lock.Lock()
+	ep.commands = append(ep.commands, pc)
+	ep.lock.Unlock()
+}
+
+func (ep *ExecPlan) Commands() []*PlannedCommand {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	return append([]*PlannedCommand{}, ep.commands...)
+}
+
+func (ep *ExecPlan) Reset() {
+	ep.lock.Lock()
+	ep.commands = nil
+	ep.lock.Unlock()
+}
+
+func (ep *ExecPlan) JSON() ([]byte, error) {
+	return json.MarshalIndent(ep.Commands(), "", "  ")
+}
+
+// ShellScript renders the plan as a POSIX sh script that would run the same commands with the same environment changes.
+func (ep *ExecPlan) ShellScript() string {
+
+	var sb strings.Builder
+
+	sb.WriteString("#!/bin/sh\nset -e\n")
+
+	for _, pc := range ep.Commands() {
+
+		sb.WriteString("\n")
+
+		line := []string{}
+
+		if len(pc.EnvRemoved) > 0 || len(pc.EnvAdded) > 0 || len(pc.EnvChanged) > 0 {
+			line = append(line, "env")
+			for _, key := range pc.EnvRemoved {
+				line = append(line, "-u", ShellQuote(key))
+			}
+			for _, kv := range sortedEnvPairs(pc.EnvAdded, pc.EnvChanged) {
+				line = append(line, ShellQuote(kv))
+			}
+		}
+
+		line = append(line, ShellQuote(pc.Path))
+
+		for _, arg := range pc.Args {
+			line = append(line, ShellQuote(arg))
+		}
+
+		if pc.Dir != "" {
+			sb.WriteString("(cd " + ShellQuote(pc.Dir) + " && " + strings.Join(line, " ") + ")\n")
+		} else {
+			sb.WriteString(strings.Join(line, " ") + "\n")
+		}
+
+	}
+
+	return sb.String()
+
+}
+
+func sortedEnvPairs(maps ...map[string]string) []string {
+	pairs := []string{}
+	for _, m := range maps {
+		for key, value := range m {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func (ex *execUtils) SetDryRun(enabled bool) {
+	ex.lock.Lock()
+	ex.dryRun = enabled
+	if enabled && ex.plan == nil {
+		ex.plan = &ExecPlan{}
+	}
+	ex.lock.Unlock()
+}
+
+func (ex *execUtils) IsDryRun() bool {
+	ex.lock.RLock()
+	defer ex.lock.RUnlock()
+	return ex.dryRun
+}
+
+// DryRunPlan returns the commands recorded while dry run mode has been enabled.
+func (ex *execUtils) DryRunPlan() *ExecPlan {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	if ex.plan == nil {
+		ex.plan = &ExecPlan{}
+	}
+	return ex.plan
+}
+
+func (ec *ExecAsyncCommand) plannedCommand() *PlannedCommand {
+
+	pc := &PlannedCommand{
+		Path: ec.Proc.Path,
+		Args: append([]string{}, ec.Proc.Args[1:]...),
+		Dir:  ec.dir,
+		Time: time.Now(),
+	}
+
+	if ec.privilege != nil {
+		pc.Sudo = true
+		pc.Privilege = ec.privilege.Name()
+	}
+
+	if ec.Proc.Env != nil {
+		pc.EnvAdded, pc.EnvChanged, pc.EnvRemoved = envDiff(os.Environ(), ec.Proc.Env)
+		for _, values := range []map[string]string{pc.EnvAdded, pc.EnvChanged} {
+			for key, value := range values {
+				values[key] = ec.redactEnvValue(key, value)
+			}
+		}
+	}
+
+	return pc
+
+}
+
+func (ec *ExecAsyncCommand) recordDryRun() {
+
+	Exec.DryRunPlan().add(ec.plannedCommand())
+
+	ec.dryRun = true
+
+	//nothing will ever be written to the pipes so let anything reading them see EOF
+	ec.closeChildEnds()
+
+}
+
+func (ec *ExecAsyncCommand) IsDryRun() bool {
+	return ec.dryRun
+}
+
+func envDiff(parent []string, child []string) (map[string]string, map[string]string, []string) {
+
+	parentMap := envToMap(parent)
+	childMap := envToMap(child)
+
+	added := map[string]string{}
+	changed := map[string]string{}
+	removed := []string{}
+
+	for key, value := range childMap {
+		if pv, ok := parentMap[key]; !ok {
+			added[key] = value
+		} else if pv != value {
+			changed[key] = value
+		}
+	}
+
+	for key := range parentMap {
+		if _, ok := childMap[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(removed)
+
+	return added, changed, removed
+
+}
+
+func envToMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			m[kv[:i]] = kv[i+1:]
+		}
+	}
+	return m
+}
@@ -0,0 +1,151 @@
+// +build !js
+
+package vutils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunRecordsPlan(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-dryrun")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	os.Setenv("VUTILS_DRYRUN_REMOVED", "1")
+	os.Setenv("VUTILS_DRYRUN_CHANGED", "old")
+	defer os.Unsetenv("VUTILS_DRYRUN_REMOVED")
+	defer os.Unsetenv("VUTILS_DRYRUN_CHANGED")
+
+	Exec.SetDryRun(true)
+	defer Exec.SetDryRun(false)
+	defer Exec.DryRunPlan().Reset()
+
+	created := filepath.Join(dir, "created")
+
+	env := InheritEnv().
+		Set("VUTILS_DRYRUN_ADDED", "it's new").
+		Set("VUTILS_DRYRUN_CHANGED", "new").
+		Set("VUTILS_API_TOKEN", "hunter2").
+		Unset("VUTILS_DRYRUN_REMOVED")
+
+	commands := []*ExecAsyncCommand{
+		Exec.CreateAsyncCommand("/usr/bin/touch", false, created),
+		Exec.CreateAsyncCommand("/bin/rm", false, "-rf", "two words").SetWorkingDir(dir).UseEnv(env).CaptureStdoutAndStdErr(false, false),
+	}
+
+	for _, ec := range commands {
+		if err := ec.StartAndWait(); err != nil {
+			t.Fatal(err)
+		} else if !ec.IsDryRun() || ec.ExitCode() != 0 || ec.Pid() != 0 || len(ec.GetStdoutBuffer()) != 0 {
+			t.Errorf("%s was not a dry run: exit code %d, pid %d", ec.path, ec.ExitCode(), ec.Pid())
+		}
+	}
+
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s: %v", created, err)
+	}
+
+	plan := Exec.DryRunPlan().Commands()
+
+	if len(plan) != 2 {
+		t.Fatalf("plan has %d commands, want 2", len(plan))
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"path", plan[0].Path, "/usr/bin/touch"},
+		{"args", strings.Join(plan[0].Args, " "), created},
+		{"no env", len(plan[0].EnvAdded) + len(plan[0].EnvChanged) + len(plan[0].EnvRemoved), 0},
+		{"dir", plan[1].Dir, dir},
+		{"added", plan[1].EnvAdded["VUTILS_DRYRUN_ADDED"], "it's new"},
+		{"changed", plan[1].EnvChanged["VUTILS_DRYRUN_CHANGED"], "new"},
+		{"redacted", plan[1].EnvAdded["VUTILS_API_TOKEN"], RedactedValue},
+		{"removed", strings.Join(plan[1].EnvRemoved, " "), "VUTILS_DRYRUN_REMOVED"},
+		{"not escalated", plan[1].Sudo, false},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	script := Exec.DryRunPlan().ShellScript()
+
+	for _, line := range []string{
+		"#!/bin/sh\nset -e\n",
+		"\n/usr/bin/touch " + ShellQuote(created) + "\n",
+		"(cd " + ShellQuote(dir) + " && env -u VUTILS_DRYRUN_REMOVED 'VUTILS_API_TOKEN=[REDACTED]' 'VUTILS_DRYRUN_ADDED=it'\"'\"'s new' VUTILS_DRYRUN_CHANGED=new /bin/rm -rf 'two words')\n",
+	} {
+		if !strings.Contains(script, line) {
+			t.Errorf("script is missing %q:\n%s", line, script)
+		}
+	}
+
+	data, err := Exec.DryRunPlan().JSON()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := []*PlannedCommand{}
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	} else if len(decoded) != 2 || decoded[1].Path != "/bin/rm" || decoded[1].EnvAdded["VUTILS_API_TOKEN"] != RedactedValue {
+		t.Errorf("JSON = %s", data)
+	}
+
+	Exec.DryRunPlan().Reset()
+
+	if len(Exec.DryRunPlan().Commands()) != 0 {
+		t.Error("Reset kept the plan")
+	}
+
+}
+
+func TestDryRunOff(t *testing.T) {
+
+	Exec.SetDryRun(true)
+	Exec.SetDryRun(false)
+	defer Exec.DryRunPlan().Reset()
+
+	ec := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "exit 3")
+
+	if err := ec.StartAndWait(); err == nil || ec.IsDryRun() || ec.ExitCode() != 3 {
+		t.Errorf("command ran with %v and exit code %d, dry run %t", err, ec.ExitCode(), ec.IsDryRun())
+	} else if len(Exec.DryRunPlan().Commands()) != 0 {
+		t.Error("a command that ran was added to the plan")
+	}
+
+}
+
+func TestEnvDiff(t *testing.T) {
+
+	added, changed, removed := envDiff(
+		[]string{"KEEP=1", "CHANGE=old", "REMOVE=x", "EMPTY="},
+		[]string{"KEEP=1", "CHANGE=new", "ADD=a=b", "EMPTY="},
+	)
+
+	if len(added) != 1 || added["ADD"] != "a=b" {
+		t.Errorf("added = %v", added)
+	} else if len(changed) != 1 || changed["CHANGE"] != "new" {
+		t.Errorf("changed = %v", changed)
+	} else if !equalStrings(removed, []string{"REMOVE"}) {
+		t.Errorf("removed = %v", removed)
+	}
+
+}
@@ -0,0 +1,215 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// CommandSpec is the fully resolved command an Executor is asked to run.
+//
+// Stdin, Stdout and Stderr are the ends the process should use. Once the executor no longer needs them (for a local
+// process that is as soon as it has started, for anything else once all output has been written) it must call
+// CloseStdio so that readers of the command see EOF.
+type CommandSpec struct {
+	Path       string
+	Args       []string
+	Env        []string
+	Dir        string
+	Privilege  string
+	Resources  *ResourceLimits
+	Namespaces *NamespaceOptions
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+	closers    []io.Closer
+	closeOnce  sync.Once
+	cmd        *exec.Cmd
+	//privilegeBackend is handed to executors that escalate on another host, see remoteExecutor
+	privilegeBackend PrivilegeBackend
+}
+
+func (cs *CommandSpec) CloseStdio() {
+	cs.closeOnce.Do(func() {
+		for _, c := range cs.closers {
+			c.Close()
+		}
+	})
+}
+
+// ExecProcess is a running (or finished) command started by an Executor.
+type ExecProcess interface {
+	Pid() int
+	Signal(sig os.Signal) error
+	Wait() error
+	//ExitCode returns the exit code once Wait has returned, -1 if the process was killed by a signal or has not exited.
+	ExitCode() int
+}
+
+type Executor interface {
+	Start(spec *CommandSpec) (ExecProcess, error)
+}
+
+// remoteExecutor is implemented by executors that run commands on another host. The privilege backend of a command is
+// then applied by the executor on that host rather than checked and applied on this one.
+type remoteExecutor interface {
+	Executor
+	runsRemotely() bool
+}
+
+type localExecutor struct{}
+
+func NewLocalExecutor() Executor {
+	return &localExecutor{}
+}
+
+func (le *localExecutor) Start(spec *CommandSpec) (ExecProcess, error) {
+
+	cmd := spec.cmd
+
+	if cmd == nil {
+		cmd = exec.Command(spec.Path, spec.Args...)
+		cmd.Env = spec.Env
+		cmd.Dir = spec.Dir
+		cmd.Stdin = spec.Stdin
+		cmd.Stdout = spec.Stdout
+		cmd.Stderr = spec.Stderr
+	}
+
+	if spec.Namespaces != nil {
+		if err := applyNamespaces(cmd, spec.Namespaces); err != nil {
+			spec.CloseStdio()
+			return nil, err
+		}
+	}
+
+	cgroup, err := le.applyResources(spec, cmd)
+
+	if err != nil {
+		spec.CloseStdio()
+		return nil, err
+	}
+
+	err = cmd.Start()
+
+	//the child has its own copies of the pipe ends now (or never will)
+	spec.CloseStdio()
+
+	if cgroup != nil {
+		cgroup.started()
+	}
+
+	if err != nil {
+		if cgroup != nil {
+			cgroup.remove()
+		}
+		return nil, err
+	}
+
+	return &localProcess{
+		cmd:    cmd,
+		cgroup: cgroup,
+	}, nil
+
+}
+
+type localProcess struct {
+	cmd       *exec.Cmd
+	cgroup    *transientCgroup
+	resources *ResourceResult
+}
+
+func (lp *localProcess) Pid() int {
+	return lp.cmd.Process.Pid
+}
+
+func (lp *localProcess) Signal(sig os.Signal) error {
+	return lp.cmd.Process.Signal(sig)
+}
+
+func (lp *localProcess) Wait() error {
+	err := lp.cmd.Wait()
+	lp.finishCgroup()
+	return err
+}
+
+func (lp *localProcess) ExitCode() int {
+	return lp.cmd.ProcessState.ExitCode()
+}
+
+var defaultExecutor = NewLocalExecutor()
+
+// SetExecutor replaces the executor used to run every ExecAsyncCommand that does not have its own, nil restores the local one.
+func (ex *execUtils) SetExecutor(executor Executor) {
+	if executor == nil {
+		executor = defaultExecutor
+	}
+	ex.lock.Lock()
+	ex.executor = executor
+	ex.lock.Unlock()
+}
+
+func (ex *execUtils) GetExecutor() Executor {
+	ex.lock.RLock()
+	defer ex.lock.RUnlock()
+	if ex.executor == nil {
+		return defaultExecutor
+	}
+	return ex.executor
+}
+
+func (ec *ExecAsyncCommand) SetExecutor(executor Executor) *ExecAsyncCommand {
+	ec.executor = executor
+	return ec
+}
+
+func (ec *ExecAsyncCommand) getExecutor() Executor {
+	if ec.executor != nil {
+		return ec.executor
+	}
+	return Exec.GetExecutor()
+}
+
+func (ec *ExecAsyncCommand) runsRemotely() bool {
+	remote, ok := ec.getExecutor().(remoteExecutor)
+	return ok && remote.runsRemotely()
+}
+
+func (ec *ExecAsyncCommand) commandSpec() *CommandSpec {
+
+	spec := &CommandSpec{
+		Path:       ec.Proc.Path,
+		Args:       append([]string{}, ec.Proc.Args[1:]...),
+		Env:        ec.Proc.Env,
+		Dir:        ec.Proc.Dir,
+		Resources:  ec.resources,
+		Namespaces: ec.namespaces,
+		Stdin:      ec.Proc.Stdin,
+		Stdout:     ec.Proc.Stdout,
+		Stderr:     ec.Proc.Stderr,
+		closers:    ec.childEnds(),
+		cmd:        ec.Proc,
+	}
+
+	if ec.privilege != nil {
+		spec.Privilege = ec.privilege.Name()
+		if ec.runsRemotely() {
+			spec.privilegeBackend = ec.privilege
+		}
+	}
+
+	return spec
+
+}
+
+func (ec *ExecAsyncCommand) Signal(sig os.Signal) error {
+	proc := ec.process()
+	if proc == nil {
+		return errors.New("Unable to signal a command that has not been started")
+	}
+	return proc.Signal(sig)
+}
@@ -0,0 +1,266 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExpectTimeout = errors.New("Timed out waiting for expected output")
+	ErrExpectEOF     = errors.New("Output ended before the expected output was seen")
+)
+
+// ExpectEOFPattern can be passed to Expect alongside real patterns to accept the end of output as a match.
+var ExpectEOFPattern = regexp.MustCompile(`\z\A.`)
+
+const expectBufferSize = 256 * 1024
+
+type ExpectMatch struct {
+	//Index of the pattern that matched
+	Index int
+	//Groups holds the whole match followed by any submatches
+	Groups []string
+	//Before is the output between the previous match and this one
+	Before string
+	EOF    bool
+}
+
+type TranscriptEntry struct {
+	Time time.Time
+	//Stream is stdout, stderr or stdin
+	Stream string
+	Data   string
+}
+
+// Expecter scripts an interaction with a command, it owns the output of the command once created.
+type Expecter struct {
+	ec         *ExecAsyncCommand
+	lock       sync.Mutex
+	changed    chan struct{}
+	buffer     []byte
+	open       int
+	transcript []TranscriptEntry
+}
+
+// Expect hands the output of the command (STDOUT and STDERR, or the terminal with UsePTY) to a new Expecter. Call it
+// before Start, the command cannot have its STDIO bound or captured as well.
+func (ec *ExecAsyncCommand) Expect() (*Expecter, error) {
+
+	if ec.stdioBound || ec.stdioCapture {
+		return nil, errors.New("Unable to expect output as STDIO is already bound or captured")
+	}
+
+	ex := &Expecter{
+		ec:      ec,
+		changed: make(chan struct{}),
+	}
+
+	ec.attachOutput(func() {
+		readers := map[string]io.Reader{
+			"stderr": ec.stderrSource(),
+		}
+		if !ec.errOnly {
+			readers["stdout"] = ec.stdoutSource()
+		}
+		ex.lock.Lock()
+		ex.open = len(readers)
+		ex.lock.Unlock()
+		for stream, reader := range readers {
+			stream, reader := stream, reader
+			ec.ioWait.Add(1)
+			go func() {
+				defer ec.ioWait.Done()
+				ex.read(stream, reader)
+			}()
+		}
+	})
+
+	//the expecter owns the output now
+	ec.stdioCapture = true
+
+	return ex, nil
+
+}
+
+func (ex *Expecter) read(stream string, reader io.Reader) {
+
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			ex.lock.Lock()
+			ex.buffer = append(ex.buffer, buf[:n]...)
+			if over := len(ex.buffer) - expectBufferSize; over > 0 {
+				ex.buffer = append(ex.buffer[:0], ex.buffer[over:]...)
+			}
+			ex.transcript = append(ex.transcript, TranscriptEntry{
+				Time:   time.Now(),
+				Stream: stream,
+				Data:   string(buf[:n]),
+			})
+			ex.notify()
+			ex.lock.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	ex.lock.Lock()
+	ex.open--
+	ex.notify()
+	ex.lock.Unlock()
+
+}
+
+// notify wakes anything waiting in Expect, it must be called with the lock held
+func (ex *Expecter) notify() {
+	close(ex.changed)
+	ex.changed = make(chan struct{})
+}
+
+// Expect waits up to timeout (forever when it is 0) for output matching one of the patterns. The earliest match in
+// the output wins and everything up to its end is consumed. ExpectEOFPattern matches once all output has been read,
+// without it reaching the end of output fails with ErrExpectEOF.
+func (ex *Expecter) Expect(timeout time.Duration, patterns ...*regexp.Regexp) (*ExpectMatch, error) {
+
+	var deadline <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+
+		ex.lock.Lock()
+
+		if match := ex.match(patterns); match != nil {
+			ex.lock.Unlock()
+			return match, nil
+		}
+
+		if ex.open <= 0 {
+			match := &ExpectMatch{
+				Index:  -1,
+				Before: string(ex.buffer),
+				EOF:    true,
+			}
+			ex.buffer = nil
+			ex.lock.Unlock()
+			for i, pattern := range patterns {
+				if pattern == ExpectEOFPattern {
+					match.Index = i
+					return match, nil
+				}
+			}
+			return match, ErrExpectEOF
+		}
+
+		changed := ex.changed
+
+		ex.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, ErrExpectTimeout
+		}
+
+	}
+
+}
+
+// ExpectString waits for any of the literal strings.
+func (ex *Expecter) ExpectString(timeout time.Duration, literals ...string) (*ExpectMatch, error) {
+	patterns := make([]*regexp.Regexp, 0, len(literals))
+	for _, literal := range literals {
+		patterns = append(patterns, regexp.MustCompile(regexp.QuoteMeta(literal)))
+	}
+	return ex.Expect(timeout, patterns...)
+}
+
+// ExpectEOF waits for the command to close its output.
+func (ex *Expecter) ExpectEOF(timeout time.Duration) error {
+	_, err := ex.Expect(timeout, ExpectEOFPattern)
+	return err
+}
+
+// match finds the earliest match of the patterns in the buffer, it must be called with the lock held
+func (ex *Expecter) match(patterns []*regexp.Regexp) *ExpectMatch {
+
+	var best *ExpectMatch
+	bestStart, bestEnd := -1, -1
+
+	for i, pattern := range patterns {
+		if pattern == nil || pattern == ExpectEOFPattern {
+			continue
+		}
+		loc := pattern.FindSubmatchIndex(ex.buffer)
+		if loc == nil || (bestStart >= 0 && loc[0] >= bestStart) {
+			continue
+		}
+		groups := make([]string, 0, len(loc)/2)
+		for g := 0; g < len(loc); g += 2 {
+			if loc[g] < 0 {
+				groups = append(groups, "")
+			} else {
+				groups = append(groups, string(ex.buffer[loc[g]:loc[g+1]]))
+			}
+		}
+		best = &ExpectMatch{
+			Index:  i,
+			Groups: groups,
+			Before: string(ex.buffer[:loc[0]]),
+		}
+		bestStart, bestEnd = loc[0], loc[1]
+	}
+
+	if best != nil {
+		ex.buffer = append(ex.buffer[:0], ex.buffer[bestEnd:]...)
+	}
+
+	return best
+
+}
+
+func (ex *Expecter) Send(data string) error {
+	ex.lock.Lock()
+	ex.transcript = append(ex.transcript, TranscriptEntry{
+		Time:   time.Now(),
+		Stream: "stdin",
+		Data:   data,
+	})
+	ex.lock.Unlock()
+	return ex.ec.Write([]byte(data))
+}
+
+func (ex *Expecter) SendLine(line string) error {
+	return ex.Send(line + "\n")
+}
+
+func (ex *Expecter) Transcript() []TranscriptEntry {
+	ex.lock.Lock()
+	defer ex.lock.Unlock()
+	return append([]TranscriptEntry{}, ex.transcript...)
+}
+
+// TranscriptString renders the transcript with what was sent prefixed by "> ".
+func (ex *Expecter) TranscriptString() string {
+	var sb strings.Builder
+	for _, entry := range ex.Transcript() {
+		if entry.Stream == "stdin" {
+			sb.WriteString("> ")
+		}
+		sb.WriteString(entry.Data)
+	}
+	return sb.String()
+}
@@ -0,0 +1,106 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpecterConversation(t *testing.T) {
+
+	ec := Exec.CreateAsyncCommand("/bin/sh", false, "-c", `printf 'Name? '; read name; echo "Hello $name, you are 42"; printf 'Continue [y/n] '; read answer; echo "answered $answer"`)
+
+	ex, err := ec.Expect()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ex.ExpectString(5*time.Second, "Name? "); err != nil {
+		t.Fatal(err)
+	} else if err := ex.SendLine("vutils"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ex.Expect(5*time.Second, regexp.MustCompile(`you are (\d+)`), regexp.MustCompile(`Hello (\w+)`))
+
+	if err != nil {
+		t.Fatal(err)
+	} else if m.Index != 1 || !equalStrings(m.Groups, []string{"Hello vutils", "vutils"}) || m.Before != "" {
+		t.Errorf("the earliest match was not used: %+v", m)
+	}
+
+	if m, err := ex.Expect(5*time.Second, regexp.MustCompile(`\[y/n\] `)); err != nil {
+		t.Fatal(err)
+	} else if m.Before != ", you are 42\nContinue " {
+		t.Errorf("Before = %q", m.Before)
+	} else if err := ex.Send("y\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, err := ex.Expect(5*time.Second, regexp.MustCompile(`never printed`), ExpectEOFPattern); err != nil {
+		t.Fatal(err)
+	} else if !m.EOF || m.Index != 1 || m.Before != "answered y\n" {
+		t.Errorf("EOF match = %+v", m)
+	}
+
+	if err := ec.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Name? > vutils\nHello vutils, you are 42\nContinue [y/n] > y\nanswered y\n"
+
+	if got := ex.TranscriptString(); got != want {
+		t.Errorf("transcript = %q, want %q", got, want)
+	} else if entries := ex.Transcript(); entries[1].Stream != "stdin" || entries[0].Stream != "stdout" {
+		t.Errorf("transcript entries = %+v", entries)
+	}
+
+}
+
+func TestExpecterErrors(t *testing.T) {
+
+	ec := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "echo done")
+	ex, err := ec.Expect()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, err := ex.ExpectString(5*time.Second, "missing"); err != ErrExpectEOF || !m.EOF || m.Index != -1 || m.Before != "done\n" {
+		t.Errorf("Expect at the end of output returned %+v, %v", m, err)
+	} else if err := ex.ExpectEOF(time.Second); err != nil {
+		t.Errorf("ExpectEOF returned %v", err)
+	}
+
+	ec.Wait()
+
+	slow := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "exec sleep 5")
+	ex, _ = slow.Expect()
+
+	if err := slow.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ex.ExpectString(50*time.Millisecond, "anything"); err != ErrExpectTimeout {
+		t.Errorf("Expect returned %v, want ErrExpectTimeout", err)
+	}
+
+	slow.Signal(os.Kill)
+	slow.Wait()
+
+	captured := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "true").CaptureStdoutAndStdErr(false, false)
+
+	if _, err := captured.Expect(); err == nil || !strings.Contains(err.Error(), "already bound or captured") {
+		t.Errorf("Expect on a captured command returned %v", err)
+	}
+
+}
@@ -0,0 +1,400 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeExecutor is a scriptable Executor for unit testing code that runs commands through vutils.Exec or the
+// ProcessManager. Install it with Exec.SetExecutor and describe the commands that are expected with On/OnPattern.
+type FakeExecutor struct {
+	lock     sync.Mutex
+	commands []*FakeCommand
+	calls    []*FakeCall
+	nextPid  int
+	//AllowUnmatched makes commands that match no FakeCommand succeed with no output instead of failing to start.
+	AllowUnmatched bool
+}
+
+type FakeCommand struct {
+	description string
+	match       func(path string, args []string) bool
+	stdout      []byte
+	stderr      []byte
+	exitCode    int
+	delay       time.Duration
+	startErr    error
+	oomKilled   bool
+	times       int
+	used        int
+}
+
+type FakeCall struct {
+	Path       string
+	Args       []string
+	Env        []string
+	Dir        string
+	Resources  *ResourceLimits
+	Namespaces *NamespaceOptions
+	Matched    bool
+	ExitCode   int
+	Time       time.Time
+}
+
+func (fc *FakeCall) String() string {
+	return strings.TrimSpace(fc.Path + " " + strings.Join(fc.Args, " "))
+}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		commands: []*FakeCommand{},
+		calls:    []*FakeCall{},
+		nextPid:  100000,
+	}
+}
+
+// On matches a command by its path (either as given or just its base name) and exact arguments, with no arguments
+// supplied any arguments match.
+func (fe *FakeExecutor) On(path string, args ...string) *FakeCommand {
+
+	return fe.add(strings.TrimSpace(path+" "+strings.Join(args, " ")), func(cpath string, cargs []string) bool {
+		if cpath != path && filepath.Base(cpath) != path {
+			return false
+		} else if len(args) == 0 {
+			return true
+		} else if len(args) != len(cargs) {
+			return false
+		}
+		for i := range args {
+			if args[i] != cargs[i] {
+				return false
+			}
+		}
+		return true
+	})
+
+}
+
+// OnPattern matches a command when pathPattern matches its path and argsPattern matches its space joined arguments.
+func (fe *FakeExecutor) OnPattern(pathPattern string, argsPattern string) *FakeCommand {
+
+	pathRx := regexp.MustCompile(pathPattern)
+	argsRx := regexp.MustCompile(argsPattern)
+
+	return fe.add(pathPattern+" "+argsPattern, func(cpath string, cargs []string) bool {
+		return pathRx.MatchString(cpath) && argsRx.MatchString(strings.Join(cargs, " "))
+	})
+
+}
+
+func (fe *FakeExecutor) add(description string, match func(path string, args []string) bool) *FakeCommand {
+	fc := &FakeCommand{
+		description: description,
+		match:       match,
+	}
+	fe.lock.Lock()
+	fe.commands = append(fe.commands, fc)
+	fe.lock.Unlock()
+	return fc
+}
+
+func (fc *FakeCommand) Stdout(out string) *FakeCommand {
+	fc.stdout = []byte(out)
+	return fc
+}
+
+func (fc *FakeCommand) Stderr(out string) *FakeCommand {
+	fc.stderr = []byte(out)
+	return fc
+}
+
+func (fc *FakeCommand) ExitCode(code int) *FakeCommand {
+	fc.exitCode = code
+	return fc
+}
+
+func (fc *FakeCommand) Delay(delay time.Duration) *FakeCommand {
+	fc.delay = delay
+	return fc
+}
+
+// OOMKilled makes the command exit as if it had been killed for running out of memory in its cgroup.
+func (fc *FakeCommand) OOMKilled() *FakeCommand {
+	fc.oomKilled = true
+	fc.exitCode = -1
+	return fc
+}
+
+func (fc *FakeCommand) FailStart(err error) *FakeCommand {
+	fc.startErr = err
+	return fc
+}
+
+// Times limits how often this FakeCommand can match, later calls fall through to the next matching FakeCommand.
+func (fc *FakeCommand) Times(times int) *FakeCommand {
+	fc.times = times
+	return fc
+}
+
+func (fe *FakeExecutor) Start(spec *CommandSpec) (ExecProcess, error) {
+
+	fe.lock.Lock()
+
+	call := &FakeCall{
+		Path:       spec.Path,
+		Args:       append([]string{}, spec.Args...),
+		Env:        spec.Env,
+		Dir:        spec.Dir,
+		Resources:  spec.Resources,
+		Namespaces: spec.Namespaces,
+		Time:       time.Now(),
+	}
+
+	fe.calls = append(fe.calls, call)
+
+	var fc *FakeCommand
+
+	for _, candidate := range fe.commands {
+		if (candidate.times == 0 || candidate.used < candidate.times) && candidate.match(spec.Path, spec.Args) {
+			fc = candidate
+			break
+		}
+	}
+
+	if fc == nil && !fe.AllowUnmatched {
+		fe.lock.Unlock()
+		spec.CloseStdio()
+		return nil, errors.New(fmt.Sprintf("No fake command matches %s", call.String()))
+	} else if fc == nil {
+		fc = &FakeCommand{}
+	} else {
+		call.Matched = true
+		fc.used++
+	}
+
+	if fc.startErr != nil {
+		fe.lock.Unlock()
+		spec.CloseStdio()
+		return nil, fc.startErr
+	}
+
+	fe.nextPid++
+
+	fp := &fakeProcess{
+		pid:      fe.nextPid,
+		exitCode: -1,
+		done:     make(chan struct{}),
+		signals:  make(chan os.Signal, 1),
+	}
+
+	fe.lock.Unlock()
+
+	go fp.run(fc, spec, func(exitCode int) {
+		fe.lock.Lock()
+		call.ExitCode = exitCode
+		fe.lock.Unlock()
+	})
+
+	return fp, nil
+
+}
+
+func (fe *FakeExecutor) Calls() []*FakeCall {
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+	return append([]*FakeCall{}, fe.calls...)
+}
+
+func (fe *FakeExecutor) Reset() {
+	fe.lock.Lock()
+	fe.calls = []*FakeCall{}
+	for _, fc := range fe.commands {
+		fc.used = 0
+	}
+	fe.lock.Unlock()
+}
+
+// AssertCalled returns an error unless a command with the given path and exact arguments has been started.
+func (fe *FakeExecutor) AssertCalled(path string, args ...string) error {
+	if fe.indexOfCall(0, path, args) < 0 {
+		return errors.New(fmt.Sprintf("Expected %s to have been run, commands run: %s", strings.TrimSpace(path+" "+strings.Join(args, " ")), fe.callList()))
+	}
+	return nil
+}
+
+func (fe *FakeExecutor) AssertNotCalled(path string, args ...string) error {
+	if fe.indexOfCall(0, path, args) >= 0 {
+		return errors.New(fmt.Sprintf("Expected %s not to have been run", strings.TrimSpace(path+" "+strings.Join(args, " "))))
+	}
+	return nil
+}
+
+// AssertOrder checks that the given commands (path followed by arguments) were run in this order, other commands may
+// have run in between.
+func (fe *FakeExecutor) AssertOrder(commands ...[]string) error {
+	from := 0
+	for _, command := range commands {
+		if len(command) == 0 {
+			continue
+		}
+		idx := fe.indexOfCall(from, command[0], command[1:])
+		if idx < 0 {
+			return errors.New(fmt.Sprintf("Expected %s to have been run after the previous commands, commands run: %s", strings.Join(command, " "), fe.callList()))
+		}
+		from = idx + 1
+	}
+	return nil
+}
+
+// AssertExpectations returns an error if any FakeCommand limited with Times was not used that many times or if a
+// command was run that matched nothing.
+func (fe *FakeExecutor) AssertExpectations() error {
+	fe.lock.Lock()
+	defer fe.lock.Unlock()
+	problems := []string{}
+	for _, fc := range fe.commands {
+		if fc.times > 0 && fc.used != fc.times {
+			problems = append(problems, fmt.Sprintf("%s expected %d times, ran %d times", fc.description, fc.times, fc.used))
+		}
+	}
+	for _, call := range fe.calls {
+		if !call.Matched {
+			problems = append(problems, fmt.Sprintf("%s was not expected", call.String()))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (fe *FakeExecutor) indexOfCall(from int, path string, args []string) int {
+	calls := fe.Calls()
+	for i := from; i < len(calls); i++ {
+		call := calls[i]
+		if call.Path != path && filepath.Base(call.Path) != path {
+			continue
+		} else if len(args) != len(call.Args) {
+			continue
+		}
+		matches := true
+		for j := range args {
+			if args[j] != call.Args[j] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return i
+		}
+	}
+	return -1
+}
+
+func (fe *FakeExecutor) callList() string {
+	list := []string{}
+	for _, call := range fe.Calls() {
+		list = append(list, call.String())
+	}
+	return "[" + strings.Join(list, ", ") + "]"
+}
+
+type fakeProcess struct {
+	pid       int
+	lock      sync.Mutex
+	exitCode  int
+	done      chan struct{}
+	signals   chan os.Signal
+	resources *ResourceResult
+}
+
+func (fp *fakeProcess) run(fc *FakeCommand, spec *CommandSpec, onExit func(int)) {
+
+	exitCode := fc.exitCode
+
+	if fc.delay > 0 {
+		select {
+		case <-time.After(fc.delay):
+		case <-fp.signals:
+			exitCode = -1
+		}
+	}
+
+	if exitCode != -1 {
+		if spec.Stdout != nil && len(fc.stdout) > 0 {
+			spec.Stdout.Write(fc.stdout)
+		}
+		if spec.Stderr != nil && len(fc.stderr) > 0 {
+			spec.Stderr.Write(fc.stderr)
+		}
+	}
+
+	spec.CloseStdio()
+
+	fp.lock.Lock()
+	fp.exitCode = exitCode
+	if fc.oomKilled {
+		fp.resources = &ResourceResult{
+			OOMKills: 1,
+		}
+	}
+	fp.lock.Unlock()
+
+	onExit(exitCode)
+
+	close(fp.done)
+
+}
+
+func (fp *fakeProcess) Pid() int {
+	return fp.pid
+}
+
+func (fp *fakeProcess) Signal(sig os.Signal) error {
+	select {
+	case <-fp.done:
+		return os.ErrProcessDone
+	case fp.signals <- sig:
+	default:
+	}
+	return nil
+}
+
+func (fp *fakeProcess) Wait() error {
+	<-fp.done
+	if code := fp.ExitCode(); code != 0 {
+		return &FakeExitError{ExitCode: code}
+	}
+	return nil
+}
+
+func (fp *fakeProcess) ResourceResult() *ResourceResult {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	return fp.resources
+}
+
+func (fp *fakeProcess) ExitCode() int {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	return fp.exitCode
+}
+
+type FakeExitError struct {
+	ExitCode int
+}
+
+func (fee *FakeExitError) Error() string {
+	if fee.ExitCode < 0 {
+		return "signal: killed"
+	}
+	return fmt.Sprintf("exit status %d", fee.ExitCode)
+}
@@ -0,0 +1,252 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runFake runs a command with fe and returns its STDOUT, STDERR and exit code
+func runFake(fe *FakeExecutor, path string, args ...string) (string, string, int, error) {
+	ec := Exec.CreateAsyncCommand(path, false, args...).SetExecutor(fe).CaptureStdoutAndStdErr(false, false)
+	err := ec.StartAndWait()
+	return string(ec.GetStdoutBuffer()), string(ec.GetStderrBuffer()), ec.ExitCode(), err
+}
+
+func TestFakeExecutorMatching(t *testing.T) {
+
+	fe := NewFakeExecutor()
+	fe.On("fake-git", "status", "--short").Stdout(" M README.md\n")
+	fe.On("/opt/fake/bin/fake-git").Stdout("any git\n")
+	fe.OnPattern(`^fake-docker$`, `^(run|exec) `).Stderr("no daemon\n").ExitCode(125)
+	fe.On("fake-ls").Times(1).Stdout("first\n")
+	fe.On("fake-ls").Stdout("later\n")
+
+	tests := []struct {
+		path     string
+		args     []string
+		stdout   string
+		stderr   string
+		exitCode int
+		err      bool
+	}{
+		{path: "fake-git", args: []string{"status", "--short"}, stdout: " M README.md\n"},
+		{path: "/usr/local/bin/fake-git", args: []string{"status", "--short"}, stdout: " M README.md\n"},
+		{path: "/opt/fake/bin/fake-git", args: []string{"log"}, stdout: "any git\n"},
+		{path: "fake-git", args: []string{"status"}, err: true},
+		{path: "fake-docker", args: []string{"run", "alpine"}, stderr: "no daemon\n", exitCode: 125, err: true},
+		{path: "fake-docker", args: []string{"ps"}, err: true},
+		{path: "fake-ls", stdout: "first\n"},
+		{path: "fake-ls", stdout: "later\n"},
+		{path: "fake-ls", args: []string{"-la"}, stdout: "later\n"},
+	}
+
+	for _, tt := range tests {
+
+		name := strings.TrimSpace(tt.path + " " + strings.Join(tt.args, " "))
+		stdout, stderr, exitCode, err := runFake(fe, tt.path, tt.args...)
+
+		if (err != nil) != tt.err {
+			t.Errorf("%s: returned %v, want an error: %t", name, err, tt.err)
+		} else if err == nil || exitCode >= 0 {
+			if stdout != tt.stdout || stderr != tt.stderr || exitCode != tt.exitCode {
+				t.Errorf("%s: ran with %q %q %d, want %q %q %d", name, stdout, stderr, exitCode, tt.stdout, tt.stderr, tt.exitCode)
+			}
+		}
+
+	}
+
+}
+
+func TestFakeExecutorUnmatched(t *testing.T) {
+
+	fe := NewFakeExecutor()
+
+	if _, _, _, err := runFake(fe, "fake-rm", "-rf", "/"); err == nil || !strings.Contains(err.Error(), "No fake command matches fake-rm -rf /") {
+		t.Errorf("unmatched command returned %v", err)
+	}
+
+	fe.AllowUnmatched = true
+
+	if stdout, _, exitCode, err := runFake(fe, "fake-rm", "-rf", "/"); err != nil || stdout != "" || exitCode != 0 {
+		t.Errorf("unmatched command with AllowUnmatched ran with %q %d %v", stdout, exitCode, err)
+	}
+
+	if err := fe.AssertExpectations(); err == nil || !strings.Contains(err.Error(), "fake-rm -rf / was not expected") {
+		t.Errorf("AssertExpectations returned %v", err)
+	}
+
+}
+
+func TestFakeExecutorFailStart(t *testing.T) {
+
+	fe := NewFakeExecutor()
+	fe.On("fake-missing").FailStart(errors.New("exec: \"fake-missing\": executable file not found in $PATH"))
+
+	if _, _, _, err := runFake(fe, "fake-missing"); err == nil || !strings.Contains(err.Error(), "executable file not found") {
+		t.Errorf("FailStart returned %v", err)
+	}
+
+}
+
+func TestFakeExecutorDelayAndSignal(t *testing.T) {
+
+	fe := NewFakeExecutor()
+	fe.On("fake-sleep").Delay(time.Hour).Stdout("never\n")
+
+	ec := Exec.CreateAsyncCommand("fake-sleep", false, "3600").SetExecutor(fe).CaptureStdoutAndStdErr(false, false)
+
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- ec.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("command with a delay exited straight away: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := ec.Signal(os.Kill); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil || ec.ExitCode() != -1 || len(ec.GetStdoutBuffer()) > 0 {
+			t.Errorf("signalled command exited with %v, exit code %d and %q", err, ec.ExitCode(), ec.GetStdoutBuffer())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signalled command did not exit")
+	}
+
+}
+
+func TestFakeExecutorAssertions(t *testing.T) {
+
+	fe := NewFakeExecutor()
+	fe.On("fake-git").Times(2)
+	fe.On("fake-make", "build").Times(1)
+	fe.On("fake-make", "test").Times(1)
+
+	for _, argv := range [][]string{{"fake-git", "fetch"}, {"fake-make", "build"}, {"fake-git", "merge"}} {
+		if _, _, _, err := runFake(fe, argv[0], argv[1:]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"called", fe.AssertCalled("fake-git", "fetch"), ""},
+		{"called by full path", fe.AssertCalled("/opt/fake/bin/fake-make", "build"), "Expected /opt/fake/bin/fake-make build to have been run"},
+		{"called with other args", fe.AssertCalled("fake-git", "fetch", "--all"), "commands run: [fake-git fetch, fake-make build, fake-git merge]"},
+		{"not called", fe.AssertNotCalled("fake-make", "test"), ""},
+		{"not called but was", fe.AssertNotCalled("fake-make", "build"), "Expected fake-make build not to have been run"},
+		{"order", fe.AssertOrder([]string{"fake-git", "fetch"}, []string{"fake-make", "build"}, []string{"fake-git", "merge"}), ""},
+		{"order with gaps", fe.AssertOrder([]string{"fake-git", "fetch"}, []string{"fake-git", "merge"}), ""},
+		{"wrong order", fe.AssertOrder([]string{"fake-git", "merge"}, []string{"fake-make", "build"}), "Expected fake-make build to have been run after the previous commands"},
+		{"repeated", fe.AssertOrder([]string{"fake-make", "build"}, []string{"fake-make", "build"}), "Expected fake-make build to have been run after"},
+		{"expectations", fe.AssertExpectations(), "fake-make test expected 1 times, ran 0 times"},
+	}
+
+	for _, tt := range tests {
+		if tt.want == "" && tt.err != nil {
+			t.Errorf("%s: returned %s", tt.name, tt.err)
+		} else if tt.want != "" && (tt.err == nil || !strings.Contains(tt.err.Error(), tt.want)) {
+			t.Errorf("%s: returned %v, want %q", tt.name, tt.err, tt.want)
+		}
+	}
+
+	if _, _, _, err := runFake(fe, "fake-make", "test"); err != nil {
+		t.Fatal(err)
+	} else if err := fe.AssertExpectations(); err != nil {
+		t.Errorf("AssertExpectations returned %s once every command ran", err)
+	}
+
+	calls := fe.Calls()
+
+	if len(calls) != 4 || calls[3].String() != "fake-make test" || !calls[3].Matched {
+		t.Errorf("Calls = %v", calls)
+	}
+
+	fe.Reset()
+
+	if len(fe.Calls()) != 0 || fe.AssertCalled("fake-git", "fetch") == nil {
+		t.Error("Reset kept the calls")
+	} else if err := fe.AssertExpectations(); err == nil || !strings.Contains(err.Error(), "fake-git expected 2 times, ran 0 times") {
+		t.Errorf("AssertExpectations after Reset returned %v", err)
+	}
+
+}
+
+func TestFakeExecutorCall(t *testing.T) {
+
+	fe := NewFakeExecutor()
+	fe.On("fake-env").ExitCode(3)
+
+	ec := Exec.CreateAsyncCommand("fake-env", false, "-0").SetExecutor(fe).SetWorkingDir("/srv").AddEnv("FAKE_EXECUTOR_TEST", "1")
+
+	if err := ec.StartAndWait(); err == nil || err.Error() != "exit status 3" {
+		t.Errorf("StartAndWait returned %v, want exit status 3", err)
+	}
+
+	calls := fe.Calls()
+
+	if len(calls) != 1 {
+		t.Fatalf("Calls = %v", calls)
+	}
+
+	call := calls[0]
+
+	if call.Dir != "/srv" || call.ExitCode != 3 || call.String() != "fake-env -0" {
+		t.Errorf("call = %+v", call)
+	}
+
+	found := false
+	for _, kv := range call.Env {
+		found = found || kv == "FAKE_EXECUTOR_TEST=1"
+	}
+
+	if !found {
+		t.Errorf("call environment is missing FAKE_EXECUTOR_TEST=1: %v", call.Env)
+	}
+
+}
+
+func TestFakeExecutorShowStdErr(t *testing.T) {
+
+	fe := NewFakeExecutor()
+	fe.On("fake-make").ExitCode(2)
+
+	Exec.SetExecutor(fe)
+	defer Exec.SetExecutor(nil)
+
+	//the exec.Cmd of a command run by the fake never ran so there is nothing to return
+	if cmd, err := Exec.ExecCommandShowStdErr("fake-make", "install"); cmd != nil || err == nil {
+		t.Errorf("ExecCommandShowStdErr returned %v %v, want no command and an error", cmd, err)
+	}
+
+	Exec.SetExecutor(nil)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	if cmd, err := Exec.ExecCommandShowStdErr("/bin/sh", "-c", "exit 0"); err != nil || cmd == nil || !cmd.ProcessState.Success() {
+		t.Errorf("ExecCommandShowStdErr returned %v %v, want the command that ran", cmd, err)
+	}
+
+}
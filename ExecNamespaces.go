@@ -0,0 +1,129 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// IDMapping maps Size ids starting at HostID outside the user namespace to ContainerID inside it.
+type IDMapping struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+type BindMount struct {
+	Source string
+	//Target defaults to Source, it has to exist already
+	Target string
+}
+
+// NamespaceOptions describe the Linux namespaces a command is started in.
+//
+// Without CAP_SYS_ADMIN a user namespace is added whenever any other namespace is requested, by default it maps the
+// current uid and gid to root inside the namespace so mounts can be set up. A new PID namespace also gets a new mount
+// namespace with /proc remounted, a new network namespace only has a loopback interface.
+type NamespaceOptions struct {
+	User    bool
+	Mount   bool
+	PID     bool
+	Network bool
+	UTS     bool
+	IPC     bool
+	//UidMappings and GidMappings replace the default mappings of the user namespace
+	UidMappings []IDMapping
+	GidMappings []IDMapping
+	//ReadOnlyBinds are bind mounted read-only in a new mount namespace, before PrivateTmp so their sources may be
+	//under /tmp but their targets may not
+	ReadOnlyBinds []BindMount
+	//PrivateTmp mounts an empty tmpfs on /tmp in a new mount namespace
+	PrivateTmp bool
+}
+
+// SandboxNamespaces isolates a command in every namespace with a private /tmp.
+func SandboxNamespaces() *NamespaceOptions {
+	return &NamespaceOptions{
+		Mount:      true,
+		PID:        true,
+		Network:    true,
+		UTS:        true,
+		IPC:        true,
+		PrivateTmp: true,
+	}
+}
+
+func (no *NamespaceOptions) BindReadOnly(source string, target string) *NamespaceOptions {
+	no.ReadOnlyBinds = append(no.ReadOnlyBinds, BindMount{
+		Source: source,
+		Target: target,
+	})
+	return no
+}
+
+func (no *NamespaceOptions) needsMount() bool {
+	return no.Mount || no.PID || no.PrivateTmp || len(no.ReadOnlyBinds) > 0
+}
+
+func (no *NamespaceOptions) any() bool {
+	return no.User || no.needsMount() || no.Network || no.UTS || no.IPC
+}
+
+// setupScript renders the shell run inside the new namespaces to prepare them before the command is executed, it is
+// empty when nothing needs preparing
+func (no *NamespaceOptions) setupScript() (string, error) {
+
+	steps := []string{}
+
+	if no.needsMount() {
+		//keep our mounts from propagating back to the host
+		steps = append(steps, "mount --make-rprivate /")
+	}
+
+	//binds go first so sources under /tmp are still visible when PrivateTmp hides it
+	for _, bind := range no.ReadOnlyBinds {
+		target := bind.Target
+		if target == "" {
+			target = bind.Source
+		}
+		if _, err := os.Stat(bind.Source); err != nil {
+			return "", errors.New(fmt.Sprintf("Unable to bind mount %s: %s", bind.Source, err.Error()))
+		} else if _, err := os.Stat(target); err != nil {
+			return "", errors.New(fmt.Sprintf("Unable to bind mount %s onto %s: %s", bind.Source, target, err.Error()))
+		} else if no.PrivateTmp && (target == "/tmp" || strings.HasPrefix(target, "/tmp/")) {
+			return "", errors.New(fmt.Sprintf("Unable to bind mount %s onto %s: it would be hidden by PrivateTmp", bind.Source, target))
+		}
+		//the remount must keep the flags locked on the source mount or it fails in a user namespace
+		remount := append([]string{"remount", "bind", "ro"}, lockedMountFlags(bind.Source)...)
+		steps = append(steps, fmt.Sprintf("mount --bind %s %s", ShellQuote(bind.Source), ShellQuote(target)))
+		steps = append(steps, fmt.Sprintf("mount -o %s %s", strings.Join(remount, ","), ShellQuote(target)))
+	}
+
+	if no.PrivateTmp {
+		steps = append(steps, "mount -t tmpfs -o mode=1777 tmpfs /tmp")
+	}
+
+	if no.PID {
+		steps = append(steps, "mount -t proc proc /proc")
+	}
+
+	if no.Network {
+		steps = append(steps, "{ ip link set lo up 2>/dev/null || true; }")
+	}
+
+	if len(steps) == 0 {
+		return "", nil
+	}
+
+	return strings.Join(append(steps, `exec "$@"`), " && "), nil
+
+}
+
+// SetNamespaces starts the command in new Linux namespaces, starting fails on other platforms.
+func (ec *ExecAsyncCommand) SetNamespaces(options *NamespaceOptions) *ExecAsyncCommand {
+	ec.namespaces = options
+	return ec
+}
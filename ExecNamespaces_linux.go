@@ -0,0 +1,124 @@
+// +build !js,linux
+
+package vutils
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func applyNamespaces(cmd *exec.Cmd, options *NamespaceOptions) error {
+
+	if !options.any() {
+		return nil
+	}
+
+	script, err := options.setupScript()
+
+	if err != nil {
+		return err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	attr := cmd.SysProcAttr
+	privileged := Privileges.HasCapability(CapSysAdmin)
+	hostUid, hostGid := os.Getuid(), os.Getgid()
+
+	if cred := attr.Credential; cred != nil && cred.Uid != 0 {
+		//setting up the namespaces needs root within them, so run as root mapped onto the requested user instead
+		privileged = false
+		hostUid, hostGid = int(cred.Uid), int(cred.Gid)
+		attr.Credential = &syscall.Credential{
+			Uid:         0,
+			Gid:         0,
+			NoSetGroups: true,
+		}
+	}
+
+	if options.needsMount() {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	if options.PID {
+		attr.Cloneflags |= syscall.CLONE_NEWPID
+	}
+	if options.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if options.UTS {
+		attr.Cloneflags |= syscall.CLONE_NEWUTS
+	}
+	if options.IPC {
+		attr.Cloneflags |= syscall.CLONE_NEWIPC
+	}
+
+	if options.User || !privileged {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = idMappings(options.UidMappings, hostUid)
+		attr.GidMappings = idMappings(options.GidMappings, hostGid)
+		//an unprivileged process may only write a gid map once setgroups is denied
+		attr.GidMappingsEnableSetgroups = privileged
+	}
+
+	if script != "" {
+		cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = "/bin/sh"
+	}
+
+	return nil
+
+}
+
+func idMappings(mappings []IDMapping, hostID int) []syscall.SysProcIDMap {
+
+	if len(mappings) == 0 {
+		return []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: hostID, Size: 1},
+		}
+	}
+
+	idMaps := make([]syscall.SysProcIDMap, 0, len(mappings))
+
+	for _, m := range mappings {
+		idMaps = append(idMaps, syscall.SysProcIDMap{
+			ContainerID: m.ContainerID,
+			HostID:      m.HostID,
+			Size:        m.Size,
+		})
+	}
+
+	return idMaps
+
+}
+
+// lockedMountFlags returns the nosuid, nodev and noexec flags of the mount holding path, a read-only remount of a
+// bind of it has to repeat them
+func lockedMountFlags(path string) []string {
+
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil
+	}
+
+	flags := []string{}
+
+	for _, f := range []struct {
+		flag int64
+		name string
+	}{
+		{syscall.MS_NOSUID, "nosuid"},
+		{syscall.MS_NODEV, "nodev"},
+		{syscall.MS_NOEXEC, "noexec"},
+	} {
+		if int64(stat.Flags)&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+
+	return flags
+
+}
@@ -0,0 +1,116 @@
+// +build !js,linux
+
+package vutils
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNamespacesSetupScript(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-ns")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	remount := strings.Join(append([]string{"remount", "bind", "ro"}, lockedMountFlags(dir)...), ",")
+
+	tests := []struct {
+		name    string
+		options *NamespaceOptions
+		want    string
+		err     string
+	}{
+		{
+			name:    "nothing to prepare",
+			options: &NamespaceOptions{User: true, UTS: true},
+			want:    "",
+		},
+		{
+			name:    "binds before the private /tmp",
+			options: (&NamespaceOptions{PrivateTmp: true, PID: true}).BindReadOnly(dir, "/home"),
+			want: "mount --make-rprivate / && mount --bind " + ShellQuote(dir) + " /home && mount -o " + remount +
+				" /home && mount -t tmpfs -o mode=1777 tmpfs /tmp && mount -t proc proc /proc && exec \"$@\"",
+		},
+		{
+			name:    "target defaults to the source",
+			options: (&NamespaceOptions{Network: true}).BindReadOnly(dir, ""),
+			want: "mount --make-rprivate / && mount --bind " + ShellQuote(dir) + " " + ShellQuote(dir) + " && mount -o " +
+				remount + " " + ShellQuote(dir) + " && { ip link set lo up 2>/dev/null || true; } && exec \"$@\"",
+		},
+		{
+			name:    "missing source",
+			options: (&NamespaceOptions{}).BindReadOnly("/nonexistent/vutils-test", "/home"),
+			err:     "Unable to bind mount /nonexistent/vutils-test",
+		},
+		{
+			name:    "target hidden by the private /tmp",
+			options: (&NamespaceOptions{PrivateTmp: true}).BindReadOnly("/home", dir),
+			err:     "it would be hidden by PrivateTmp",
+		},
+	}
+
+	for _, tt := range tests {
+
+		if tt.name == "target hidden by the private /tmp" && !strings.HasPrefix(dir, "/tmp/") {
+			continue
+		}
+
+		got, err := tt.options.setupScript()
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: setupScript returned %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: setupScript returned %s", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: setupScript = %q, want %q", tt.name, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestLockedMountFlags(t *testing.T) {
+
+	if flags := lockedMountFlags("/nonexistent/vutils-test"); len(flags) != 0 {
+		t.Errorf("lockedMountFlags of a missing path = %v", flags)
+	}
+
+	mounts, err := ioutil.ReadFile("/proc/self/mounts")
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, line := range strings.Split(string(mounts), "\n") {
+
+		fields := strings.Fields(line)
+
+		if len(fields) < 4 || fields[1] != "/proc" {
+			continue
+		}
+
+		options := "," + fields[3] + ","
+		want := []string{}
+
+		for _, flag := range []string{"nosuid", "nodev", "noexec"} {
+			if strings.Contains(options, ","+flag+",") {
+				want = append(want, flag)
+			}
+		}
+
+		if got := lockedMountFlags("/proc"); !equalStrings(got, want) {
+			t.Errorf("lockedMountFlags(/proc) = %v, want %v", got, want)
+		}
+
+	}
+
+}
@@ -0,0 +1,19 @@
+// +build !js,!linux
+
+package vutils
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func applyNamespaces(cmd *exec.Cmd, options *NamespaceOptions) error {
+	if !options.any() {
+		return nil
+	}
+	return errors.New("Namespaces are only supported on Linux")
+}
+
+func lockedMountFlags(path string) []string {
+	return nil
+}
@@ -0,0 +1,264 @@
+// +build !js
+
+package vutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type PipelineStderrMode int
+
+const (
+	//stderr of the stage is written to the stderr of this process
+	PipelineStderrInherit PipelineStderrMode = iota
+	//stderr of the stage is thrown away
+	PipelineStderrDiscard
+	//stderr of the stage is captured and returned in its PipelineStageResult
+	PipelineStderrCapture
+	//stderr of the stage is merged into its stdout (2>&1) and flows down the pipeline
+	PipelineStderrToStdout
+)
+
+type ExecPipeline struct {
+	stages       []*pipelineStage
+	stdin        io.Reader
+	stdout       io.Writer
+	stdoutBuffer bytes.Buffer
+	started      bool
+	waited       bool
+	result       *PipelineResult
+}
+
+type pipelineStage struct {
+	cmd          *ExecAsyncCommand
+	stderrMode   PipelineStderrMode
+	stderrWriter io.Writer
+	stderrBuffer bytes.Buffer
+}
+
+type PipelineStageResult struct {
+	Index    int
+	Path     string
+	Args     []string
+	ExitCode int
+	Err      error
+	Stderr   []byte
+}
+
+type PipelineResult struct {
+	Stages []*PipelineStageResult
+	//exit code of the pipeline using pipefail semantics - the exit code of the last stage to fail or 0
+	ExitCode int
+	//index of the last stage to fail or -1 if all stages succeeded
+	FailedStage int
+}
+
+func (pr *PipelineResult) Success() bool {
+	return pr.FailedStage < 0
+}
+
+func (ex *execUtils) CreatePipeline(stages ...*ExecAsyncCommand) *ExecPipeline {
+
+	pl := &ExecPipeline{
+		stages: []*pipelineStage{},
+	}
+
+	for _, cmd := range stages {
+		pl.Pipe(cmd)
+	}
+
+	return pl
+
+}
+
+func (pl *ExecPipeline) Pipe(cmd *ExecAsyncCommand) *ExecPipeline {
+	pl.stages = append(pl.stages, &pipelineStage{
+		cmd:        cmd,
+		stderrMode: PipelineStderrInherit,
+	})
+	return pl
+}
+
+func (pl *ExecPipeline) SetStderr(index int, mode PipelineStderrMode) *ExecPipeline {
+	if index >= 0 && index < len(pl.stages) {
+		pl.stages[index].stderrMode = mode
+		pl.stages[index].stderrWriter = nil
+	}
+	return pl
+}
+
+func (pl *ExecPipeline) SetStderrWriter(index int, w io.Writer) *ExecPipeline {
+	if index >= 0 && index < len(pl.stages) {
+		pl.stages[index].stderrMode = PipelineStderrInherit
+		pl.stages[index].stderrWriter = w
+	}
+	return pl
+}
+
+func (pl *ExecPipeline) SetStdin(r io.Reader) *ExecPipeline {
+	pl.stdin = r
+	return pl
+}
+
+func (pl *ExecPipeline) SetStdout(w io.Writer) *ExecPipeline {
+	pl.stdout = w
+	return pl
+}
+
+func (pl *ExecPipeline) GetStdoutBuffer() []byte {
+	return pl.stdoutBuffer.Bytes()
+}
+
+func (pl *ExecPipeline) Start() error {
+
+	if pl.started {
+		return errors.New("Pipeline has already been started")
+	} else if len(pl.stages) == 0 {
+		return errors.New("Pipeline has no stages")
+	}
+
+	for i, stage := range pl.stages {
+		if stage.cmd == nil {
+			return errors.New(fmt.Sprintf("Pipeline stage %d has no command", i))
+		} else if stage.cmd.proc != nil {
+			return errors.New(fmt.Sprintf("Pipeline stage %d has already been started", i))
+		} else if stage.cmd.stdioBound || stage.cmd.stdioCapture {
+			return errors.New(fmt.Sprintf("Pipeline stage %d has its STDIO bound or captured, the pipeline must own it", i))
+		} else if stage.cmd.retry != nil {
+			return errors.New(fmt.Sprintf("Pipeline stage %d has a retry policy, stages of a pipeline cannot be retried on their own", i))
+		}
+	}
+
+	//connect each stage to the next with an os pipe so data flows directly between the children
+
+	last := len(pl.stages) - 1
+
+	for i, stage := range pl.stages {
+
+		cmd := stage.cmd
+
+		if i == 0 && pl.stdin != nil {
+			cmd.redirectStdin(pl.stdin, nil)
+		}
+
+		if i < last {
+			r, w, err := os.Pipe()
+			if err != nil {
+				for _, s := range pl.stages {
+					s.cmd.closeStdio()
+				}
+				return err
+			}
+			cmd.redirectStdout(w, w)
+			pl.stages[i+1].cmd.redirectStdin(r, r)
+		} else if pl.stdout != nil {
+			cmd.redirectStdout(pl.stdout, nil)
+		} else {
+			cmd.redirectStdout(&pl.stdoutBuffer, nil)
+		}
+
+		switch {
+		case stage.stderrWriter != nil:
+			cmd.redirectStderr(stage.stderrWriter, nil)
+		case stage.stderrMode == PipelineStderrDiscard:
+			cmd.redirectStderr(ioutil.Discard, nil)
+		case stage.stderrMode == PipelineStderrCapture:
+			cmd.redirectStderr(&stage.stderrBuffer, nil)
+		case stage.stderrMode == PipelineStderrToStdout:
+			cmd.redirectStderr(cmd.Proc.Stdout, nil)
+		default:
+			cmd.redirectStderr(os.Stderr, nil)
+		}
+
+	}
+
+	for i, stage := range pl.stages {
+		if err := stage.cmd.Start(); err != nil {
+			for _, started := range pl.stages[:i] {
+				started.cmd.Signal(os.Kill)
+				started.cmd.Wait()
+			}
+			for _, s := range pl.stages[i:] {
+				s.cmd.closeStdio()
+			}
+			return errors.New(fmt.Sprintf("Unable to start pipeline stage %d (%s): %s", i, stage.cmd.path, err.Error()))
+		}
+	}
+
+	pl.started = true
+
+	return nil
+
+}
+
+func (pl *ExecPipeline) Wait() (*PipelineResult, error) {
+
+	if !pl.started {
+		return nil, errors.New("Pipeline has not been started")
+	} else if pl.waited {
+		return pl.result, pl.result.err()
+	}
+
+	res := &PipelineResult{
+		Stages:      make([]*PipelineStageResult, len(pl.stages)),
+		FailedStage: -1,
+	}
+
+	for i, stage := range pl.stages {
+
+		err := stage.cmd.Wait()
+
+		sres := &PipelineStageResult{
+			Index:    i,
+			Path:     stage.cmd.path,
+			Args:     stage.cmd.args,
+			ExitCode: stage.cmd.ExitCode(),
+			Err:      err,
+		}
+
+		if stage.stderrMode == PipelineStderrCapture && stage.stderrWriter == nil {
+			sres.Stderr = stage.stderrBuffer.Bytes()
+		}
+
+		if err != nil || sres.ExitCode != 0 {
+			res.FailedStage = i
+			res.ExitCode = sres.ExitCode
+		}
+
+		res.Stages[i] = sres
+
+	}
+
+	pl.waited = true
+	pl.result = res
+
+	return res, res.err()
+
+}
+
+func (pl *ExecPipeline) Run() (*PipelineResult, error) {
+
+	if err := pl.Start(); err != nil {
+		return nil, err
+	}
+
+	return pl.Wait()
+
+}
+
+func (pr *PipelineResult) err() error {
+
+	if pr.FailedStage < 0 {
+		return nil
+	}
+
+	stage := pr.Stages[pr.FailedStage]
+
+	return errors.New(fmt.Sprintf("Pipeline stage %d (%s) failed with exit code %d: %v", stage.Index, stage.Path, stage.ExitCode, stage.Err))
+
+}
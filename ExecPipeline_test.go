@@ -0,0 +1,180 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func shellStage(script string) *ExecAsyncCommand {
+	return Exec.CreateAsyncCommand("/bin/sh", false, "-c", script)
+}
+
+func TestPipelineRun(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		stages      []string
+		stdin       string
+		stderr      map[int]PipelineStderrMode
+		stdout      string
+		exitCode    int
+		failedStage int
+		stageStderr map[int]string
+	}{
+		{
+			name:        "single stage",
+			stages:      []string{"echo hello"},
+			stdout:      "hello\n",
+			failedStage: -1,
+		},
+		{
+			name:        "stages are connected",
+			stages:      []string{"printf 'b\\na\\nc\\n'", "sort", "tr a-z A-Z"},
+			stdout:      "A\nB\nC\n",
+			failedStage: -1,
+		},
+		{
+			name:        "stdin feeds the first stage",
+			stages:      []string{"cat", "wc -l"},
+			stdin:       "1\n2\n3\n",
+			stdout:      "3\n",
+			failedStage: -1,
+		},
+		{
+			name:        "pipefail reports the last failed stage",
+			stages:      []string{"echo a; exit 3", "cat; exit 4", "cat"},
+			stdout:      "a\n",
+			exitCode:    4,
+			failedStage: 1,
+		},
+		{
+			name:        "stderr captured per stage",
+			stages:      []string{"echo out; echo first >&2", "cat; echo second >&2"},
+			stderr:      map[int]PipelineStderrMode{0: PipelineStderrCapture, 1: PipelineStderrCapture},
+			stdout:      "out\n",
+			failedStage: -1,
+			stageStderr: map[int]string{0: "first\n", 1: "second\n"},
+		},
+		{
+			name:        "stderr merged into stdout",
+			stages:      []string{"echo merged >&2", "tr a-z A-Z"},
+			stderr:      map[int]PipelineStderrMode{0: PipelineStderrToStdout},
+			stdout:      "MERGED\n",
+			failedStage: -1,
+		},
+		{
+			name:        "stderr discarded",
+			stages:      []string{"echo gone >&2; echo kept"},
+			stderr:      map[int]PipelineStderrMode{0: PipelineStderrDiscard},
+			stdout:      "kept\n",
+			failedStage: -1,
+		},
+	}
+
+	for _, tt := range tests {
+
+		pl := Exec.CreatePipeline()
+
+		for _, script := range tt.stages {
+			pl.Pipe(shellStage(script))
+		}
+
+		for index, mode := range tt.stderr {
+			pl.SetStderr(index, mode)
+		}
+
+		if tt.stdin != "" {
+			pl.SetStdin(strings.NewReader(tt.stdin))
+		}
+
+		res, err := pl.Run()
+
+		if res == nil {
+			t.Errorf("%s: Run returned no result: %v", tt.name, err)
+			continue
+		} else if (err != nil) != (tt.failedStage >= 0) {
+			t.Errorf("%s: Run returned %v", tt.name, err)
+		}
+
+		if got := strings.TrimLeft(string(pl.GetStdoutBuffer()), " "); got != tt.stdout {
+			t.Errorf("%s: stdout = %q, want %q", tt.name, got, tt.stdout)
+		}
+
+		if res.ExitCode != tt.exitCode || res.FailedStage != tt.failedStage || res.Success() != (tt.failedStage < 0) {
+			t.Errorf("%s: exit code %d from stage %d, want %d from stage %d", tt.name, res.ExitCode, res.FailedStage, tt.exitCode, tt.failedStage)
+		}
+
+		for index, stderr := range tt.stageStderr {
+			if got := string(res.Stages[index].Stderr); got != stderr {
+				t.Errorf("%s: stderr of stage %d = %q, want %q", tt.name, index, got, stderr)
+			}
+		}
+
+	}
+
+}
+
+func TestPipelineStdoutWriter(t *testing.T) {
+
+	var out, errOut bytes.Buffer
+
+	_, err := Exec.CreatePipeline(shellStage("echo one; echo two >&2"), shellStage("cat")).
+		SetStdout(&out).
+		SetStderrWriter(0, &errOut).
+		Run()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if out.String() != "one\n" || errOut.String() != "two\n" {
+		t.Errorf("stdout %q and stderr %q, want \"one\\n\" and \"two\\n\"", out.String(), errOut.String())
+	} else if len(Exec.CreatePipeline().GetStdoutBuffer()) != 0 {
+		t.Error("a new pipeline has output")
+	}
+
+}
+
+func TestPipelineStartErrors(t *testing.T) {
+
+	started := shellStage("true")
+
+	if err := started.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	started.Wait()
+
+	tests := []struct {
+		name string
+		pl   *ExecPipeline
+		want string
+	}{
+		{"no stages", Exec.CreatePipeline(), "Pipeline has no stages"},
+		{"nil stage", Exec.CreatePipeline(shellStage("true"), nil), "Pipeline stage 1 has no command"},
+		{"started stage", Exec.CreatePipeline(started), "Pipeline stage 0 has already been started"},
+		{"captured stage", Exec.CreatePipeline(shellStage("true").CaptureStdoutAndStdErr(false, false)), "Pipeline stage 0 has its STDIO bound or captured"},
+		{"retried stage", Exec.CreatePipeline(shellStage("true").Retry(NewRetryPolicy(3))), "Pipeline stage 0 has a retry policy"},
+		{"missing binary", Exec.CreatePipeline(shellStage("true"), Exec.CreateAsyncCommand("/nonexistent/vutils-test", false)), "Unable to start pipeline stage 1 (/nonexistent/vutils-test)"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.pl.Start(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Start returned %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	pl := Exec.CreatePipeline(shellStage("true"))
+
+	if _, err := pl.Wait(); err == nil || err.Error() != "Pipeline has not been started" {
+		t.Errorf("Wait before Start returned %v", err)
+	} else if _, err := pl.Run(); err != nil {
+		t.Fatal(err)
+	} else if err := pl.Start(); err == nil || err.Error() != "Pipeline has already been started" {
+		t.Errorf("second Start returned %v", err)
+	} else if res, err := pl.Wait(); err != nil || !res.Success() {
+		t.Errorf("second Wait returned %v %v", res, err)
+	}
+
+}
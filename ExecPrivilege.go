@@ -0,0 +1,259 @@
+// +build !js
+
+package vutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+)
+
+var ErrPrivilegeUnavailable = errors.New("Privilege escalation is not available")
+
+// PrivilegedCommand is the command a PrivilegeBackend rewrites so that it runs with the requested privileges.
+type PrivilegedCommand struct {
+	Path       string
+	Args       []string
+	Credential *PrivilegeCredential
+}
+
+type PrivilegeCredential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+}
+
+type PrivilegeBackend interface {
+	Name() string
+	//Available returns an error wrapping ErrPrivilegeUnavailable when the backend cannot escalate from this process.
+	Available() error
+	Elevate(cmd *PrivilegedCommand) error
+}
+
+// privilegeValidator is implemented by backends that have to check something right before every start of the
+// command, e.g. that a password is accepted. It is not called in dry-run mode.
+type privilegeValidator interface {
+	validate() error
+}
+
+func privilegeUnavailable(backend string, format string, args ...interface{}) error {
+	return &PrivilegeError{
+		Backend: backend,
+		Reason:  fmt.Sprintf(format, args...),
+	}
+}
+
+type PrivilegeError struct {
+	Backend string
+	Reason  string
+}
+
+func (pe *PrivilegeError) Error() string {
+	return fmt.Sprintf("%s: unable to escalate using %s: %s", ErrPrivilegeUnavailable.Error(), pe.Backend, pe.Reason)
+}
+
+func (pe *PrivilegeError) Unwrap() error {
+	return ErrPrivilegeUnavailable
+}
+
+type SudoBackend struct {
+	//Password validates the credentials with sudo -v before the command is started with sudo -n, so it is never seen
+	//by the command. When empty sudo must not require one.
+	Password string
+	User     string
+}
+
+func (sb *SudoBackend) Name() string {
+	return "sudo"
+}
+
+func (sb *SudoBackend) Available() error {
+
+	if _, err := exec.LookPath("sudo"); err != nil {
+		return privilegeUnavailable(sb.Name(), "sudo is not installed")
+	}
+
+	//the password is validated when the command starts
+	if sb.Password != "" {
+		return nil
+	}
+
+	if err := exec.Command("sudo", "-n", "true").Run(); err != nil {
+		return privilegeUnavailable(sb.Name(), "a password is required")
+	}
+
+	return nil
+
+}
+
+// validate caches the credentials of sudo using the password so the command can be started with sudo -n, it runs
+// before every start of the command
+func (sb *SudoBackend) validate() error {
+
+	if sb.Password == "" {
+		return nil
+	}
+
+	cmd := exec.Command("sudo", "-S", "-p", "", "-v")
+	cmd.Stdin = bytes.NewBufferString(sb.Password + "\n")
+
+	if err := cmd.Run(); err != nil {
+		return privilegeUnavailable(sb.Name(), "the supplied password was rejected")
+	}
+
+	return nil
+
+}
+
+// Elevate runs the command with sudo -n, with a Password the credentials are validated right before every start.
+// sudo configured not to cache credentials (timestamp_timeout=0) then fails to start the command.
+func (sb *SudoBackend) Elevate(cmd *PrivilegedCommand) error {
+
+	args := []string{"-n"}
+
+	if sb.User != "" {
+		args = append(args, "-u", sb.User)
+	}
+
+	cmd.Args = append(append(args, "--", cmd.Path), cmd.Args...)
+	cmd.Path = "sudo"
+
+	return nil
+
+}
+
+type DoasBackend struct {
+	User string
+}
+
+func (db *DoasBackend) Name() string {
+	return "doas"
+}
+
+func (db *DoasBackend) Available() error {
+
+	if _, err := exec.LookPath("doas"); err != nil {
+		return privilegeUnavailable(db.Name(), "doas is not installed")
+	}
+
+	args := []string{"-n"}
+
+	if db.User != "" {
+		args = append(args, "-u", db.User)
+	}
+
+	if err := exec.Command("doas", append(args, "true")...).Run(); err != nil {
+		return privilegeUnavailable(db.Name(), "doas is not permitted without a password")
+	}
+
+	return nil
+
+}
+
+func (db *DoasBackend) Elevate(cmd *PrivilegedCommand) error {
+
+	args := []string{"-n"}
+
+	if db.User != "" {
+		args = append(args, "-u", db.User)
+	}
+
+	cmd.Args = append(append(args, "--", cmd.Path), cmd.Args...)
+	cmd.Path = "doas"
+
+	return nil
+
+}
+
+// CredentialBackend runs the command directly as another user and group by setting the credentials of the child.
+type CredentialBackend struct {
+	Credential PrivilegeCredential
+}
+
+func (cb *CredentialBackend) Name() string {
+	return "credential"
+}
+
+func (cb *CredentialBackend) Available() error {
+
+	if !credentialsSupported {
+		return privilegeUnavailable(cb.Name(), "setting process credentials is not supported on this platform")
+	}
+
+	if Privileges.CanSetCredentials() {
+		return nil
+	} else if uint32(os.Geteuid()) == cb.Credential.Uid && uint32(os.Getegid()) == cb.Credential.Gid && len(cb.Credential.Groups) == 0 {
+		return nil
+	}
+
+	return privilegeUnavailable(cb.Name(), "changing to uid %d gid %d requires root or CAP_SETUID and CAP_SETGID", cb.Credential.Uid, cb.Credential.Gid)
+
+}
+
+func (cb *CredentialBackend) Elevate(cmd *PrivilegedCommand) error {
+
+	cred := cb.Credential
+
+	if cred.Groups != nil {
+		cred.Groups = append([]uint32{}, cred.Groups...)
+	}
+
+	cmd.Credential = &cred
+
+	return nil
+
+}
+
+func (ex *execUtils) RunAsUser(username string, group string) (*CredentialBackend, error) {
+
+	u, err := user.Lookup(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gidStr := u.Gid
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		gidStr = g.Gid
+	}
+
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []uint32{}
+
+	if groupIds, err := u.GroupIds(); err == nil {
+		for _, gs := range groupIds {
+			if g, err := strconv.ParseUint(gs, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
+		}
+	}
+
+	return &CredentialBackend{
+		Credential: PrivilegeCredential{
+			Uid:    uint32(uid),
+			Gid:    uint32(gid),
+			Groups: groups,
+		},
+	}, nil
+
+}
@@ -0,0 +1,170 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSudoScript records its arguments and what it read on STDIN, it accepts the password secret and runs the command
+const fakeSudoScript = `#!/bin/sh
+echo "$*" >> "$FAKE_SUDO_LOG"
+case "$1" in
+-S) IFS= read -r pw; [ "$pw" = secret ] || exit 1; echo validated >> "$FAKE_SUDO_LOG"; exit 0 ;;
+esac
+while [ $# -gt 0 ]; do
+  case "$1" in
+  --) shift; break ;;
+  -u) shift 2 ;;
+  -*) shift ;;
+  *) break ;;
+  esac
+done
+exec "$@"
+`
+
+// withFakeSudo puts a fake sudo first on PATH, it returns the file it logs to and a function putting PATH back
+func withFakeSudo(t *testing.T) (string, func()) {
+
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vutils-sudo")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudoScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(dir, "log")
+	path := os.Getenv("PATH")
+
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	os.Setenv("FAKE_SUDO_LOG", logPath)
+
+	return logPath, func() {
+		os.Setenv("PATH", path)
+		os.Unsetenv("FAKE_SUDO_LOG")
+		os.RemoveAll(dir)
+	}
+
+}
+
+func readFakeSudoLog(t *testing.T, logPath string) []string {
+	t.Helper()
+	data, err := ioutil.ReadFile(logPath)
+	if os.IsNotExist(err) {
+		return []string{}
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestSudoBackendPassword(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		backend *SudoBackend
+		stdin   string
+		stdout  string
+		err     string
+		sudoLog []string
+	}{
+		{
+			name:    "password validated once before the command",
+			backend: &SudoBackend{Password: "secret"},
+			stdin:   "input\n",
+			stdout:  "input\n",
+			sudoLog: []string{"-S -p  -v", "validated", "-n -- cat"},
+		},
+		{
+			name:    "user",
+			backend: &SudoBackend{Password: "secret", User: "postgres"},
+			stdout:  "",
+			sudoLog: []string{"-S -p  -v", "validated", "-n -u postgres -- cat"},
+		},
+		{
+			name:    "rejected password",
+			backend: &SudoBackend{Password: "wrong"},
+			err:     "the supplied password was rejected",
+			sudoLog: []string{"-S -p  -v"},
+		},
+		{
+			name:    "no password",
+			backend: &SudoBackend{},
+			stdout:  "",
+			sudoLog: []string{"-n true", "-n -- cat"},
+		},
+	}
+
+	for _, tt := range tests {
+
+		logPath, restore := withFakeSudo(t)
+
+		ec := Exec.CreateAsyncCommand("cat", false)
+
+		if err := ec.Escalate(tt.backend); err != nil {
+			t.Errorf("%s: Escalate returned %s", tt.name, err)
+			restore()
+			continue
+		}
+
+		ec.CaptureStdoutAndStdErr(false, false).SetStdinString(tt.stdin)
+
+		err := ec.StartAndWait()
+
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: StartAndWait returned %s", tt.name, err)
+		} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: StartAndWait returned %v, want %q", tt.name, err, tt.err)
+		} else if got := string(ec.GetStdoutBuffer()); tt.err == "" && got != tt.stdout {
+			t.Errorf("%s: the command read %q, want %q", tt.name, got, tt.stdout)
+		}
+
+		if got := readFakeSudoLog(t, logPath); !equalStrings(got, tt.sudoLog) {
+			t.Errorf("%s: sudo ran with %q, want %q", tt.name, got, tt.sudoLog)
+		}
+
+		restore()
+
+	}
+
+}
+
+func TestSudoBackendDryRun(t *testing.T) {
+
+	logPath, restore := withFakeSudo(t)
+	defer restore()
+
+	Exec.SetDryRun(true)
+	defer Exec.SetDryRun(false)
+	defer Exec.DryRunPlan().Reset()
+
+	for _, backend := range []*SudoBackend{{}, {Password: "secret"}} {
+
+		ec := Exec.CreateAsyncCommand("cat", false)
+
+		if err := ec.Escalate(backend); err != nil {
+			t.Fatal(err)
+		} else if err := ec.StartAndWait(); err != nil {
+			t.Fatal(err)
+		}
+
+	}
+
+	if got := readFakeSudoLog(t, logPath); len(got) > 0 {
+		t.Errorf("sudo ran in dry-run mode: %q", got)
+	}
+
+	if commands := Exec.DryRunPlan().Commands(); len(commands) != 2 || commands[1].Privilege != "sudo" {
+		t.Errorf("dry-run plan = %v", commands)
+	}
+
+}
@@ -0,0 +1,26 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const credentialsSupported = true
+
+func applyPrivilegeCredential(cmd *exec.Cmd, cred *PrivilegeCredential) error {
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    cred.Uid,
+		Gid:    cred.Gid,
+		Groups: cred.Groups,
+	}
+
+	return nil
+
+}
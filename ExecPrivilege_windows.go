@@ -0,0 +1,15 @@
+// +build !js,windows
+
+package vutils
+
+import (
+	"os/exec"
+)
+
+const credentialsSupported = false
+
+func applyPrivilegeCredential(cmd *exec.Cmd, cred *PrivilegeCredential) error {
+
+	return privilegeUnavailable("credential", "setting process credentials is not supported on windows")
+
+}
@@ -0,0 +1,67 @@
+// +build !js
+
+package vutils
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"syscall"
+)
+
+// UsePTY runs the command on a pseudo terminal so it behaves as it would when run interactively. Call it before
+// binding or capturing output. STDOUT and STDERR both arrive through the STDOUT reader, the STDERR reader is empty.
+func (ec *ExecAsyncCommand) UsePTY() *ExecAsyncCommand {
+	if ec.pty {
+		return ec
+	} else if ec.stdioBound || ec.stdioCapture {
+		logWarn("Unable to use a PTY as STDIO is already bound or captured.", LogF("command", ec.path))
+		return ec
+	}
+	ec.pty = true
+	ec.errOnly = false
+	ec.attachPTY()
+	return ec
+}
+
+func (ec *ExecAsyncCommand) IsPTY() bool {
+	return ec.pty
+}
+
+// attachPTY replaces the pipes set up by init with a new pseudo terminal
+func (ec *ExecAsyncCommand) attachPTY() {
+
+	master, slave, err := openPTY()
+
+	if err != nil {
+		ec.ptyErr = err
+		return
+	}
+
+	ec.ptyErr = nil
+
+	//the slave is closed on our side as soon as the command has started, through the STDIN child end
+	ec.redirectStdin(slave, slave)
+	ec.redirectStdout(slave, nil)
+	ec.redirectStderr(slave, nil)
+
+	ec.ptyMaster = master
+	ec.reader = master
+	ec.writer = master
+	ec.error = ioutil.NopCloser(bytes.NewReader(nil))
+
+	if ec.Proc.SysProcAttr == nil {
+		ec.Proc.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	setControllingTerminal(ec.Proc.SysProcAttr)
+
+}
+
+// ResizePTY sets the window size of the terminal of a command running with UsePTY.
+func (ec *ExecAsyncCommand) ResizePTY(rows uint16, cols uint16) error {
+	if !ec.pty || ec.ptyMaster == nil {
+		return errors.New("Command is not running on a PTY")
+	}
+	return setPTYSize(ec.ptyMaster, rows, cols)
+}
@@ -0,0 +1,106 @@
+// +build !js,linux
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY allocates a pseudo terminal returning its master and slave ends
+func openPTY() (*os.File, *os.File, error) {
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	var number uint32
+
+	if err := ptyIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, errors.New(fmt.Sprintf("Unable to unlock PTY: %s", err.Error()))
+	} else if err := ptyIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, nil, errors.New(fmt.Sprintf("Unable to get PTY number: %s", err.Error()))
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+
+}
+
+type ptyWinsize struct {
+	Rows   uint16
+	Cols   uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func setPTYSize(pty *os.File, rows uint16, cols uint16) error {
+	ws := &ptyWinsize{
+		Rows: rows,
+		Cols: cols,
+	}
+	return ptyIoctl(pty, syscall.TIOCSWINSZ, unsafe.Pointer(ws))
+}
+
+// ptyIoctl runs the ioctl through SyscallConn so the file stays in non-blocking mode and can be closed while read
+func ptyIoctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+
+	rc, err := f.SyscallConn()
+
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+
+	if err != nil {
+		return err
+	} else if errno != 0 {
+		return errno
+	}
+
+	return nil
+
+}
+
+func setControllingTerminal(attr *syscall.SysProcAttr) {
+	attr.Setsid = true
+	attr.Setctty = true
+	//the slave is the STDIN of the child
+	attr.Ctty = 0
+}
+
+// terminalSize reads the window size of the terminal f refers to
+func terminalSize(f *os.File) (uint16, uint16, error) {
+	ws := &ptyWinsize{}
+	if err := ptyIoctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(ws)); err != nil {
+		return 0, 0, err
+	}
+	return ws.Rows, ws.Cols, nil
+}
+
+func windowSizeSignals() []os.Signal {
+	return []os.Signal{syscall.SIGWINCH}
+}
+
+func isWindowSizeSignal(sig os.Signal) bool {
+	return sig == syscall.SIGWINCH
+}
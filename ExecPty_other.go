@@ -0,0 +1,31 @@
+// +build !js,!linux
+
+package vutils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("PTY mode is only supported on Linux")
+}
+
+func setPTYSize(pty *os.File, rows uint16, cols uint16) error {
+	return errors.New("PTY mode is only supported on Linux")
+}
+
+func setControllingTerminal(attr *syscall.SysProcAttr) {}
+
+func terminalSize(f *os.File) (uint16, uint16, error) {
+	return 0, 0, errors.New("PTY mode is only supported on Linux")
+}
+
+func windowSizeSignals() []os.Signal {
+	return nil
+}
+
+func isWindowSizeSignal(sig os.Signal) bool {
+	return false
+}
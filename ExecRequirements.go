@@ -0,0 +1,404 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var defaultVersionPattern = regexp.MustCompile(`[vV]?(\d+(?:\.\d+){1,2}(?:-[0-9A-Za-z.-]+)?)`)
+
+// strongVersionPattern is what a version found in the output of a failed probe has to look like to be believed
+var strongVersionPattern = regexp.MustCompile(`\d+\.\d+`)
+
+const defaultVersionTimeout = 10 * time.Second
+
+// Requirement describes a binary that has to be installed, optionally at a version satisfying Constraint.
+type Requirement struct {
+	Name string
+	//Constraint is checked against the version reported by the binary, see VersionConstraint, empty only needs the
+	//binary to exist
+	Constraint string
+	//VersionArgs are passed to the binary to print its version, --version when empty
+	VersionArgs []string
+	//VersionPattern finds the version in the output of the probe, the first group is used when it has one
+	VersionPattern *regexp.Regexp
+	//SearchDirs are searched before PATH
+	SearchDirs []string
+	//Timeout of the version probe, 10 seconds when 0
+	Timeout time.Duration
+	//Optional requirements are reported but do not fail the report
+	Optional bool
+}
+
+func Require(name string, constraint string) *Requirement {
+	return &Requirement{
+		Name:       name,
+		Constraint: constraint,
+	}
+}
+
+func (r *Requirement) VersionFlag(args ...string) *Requirement {
+	r.VersionArgs = args
+	return r
+}
+
+func (r *Requirement) VersionRegexp(pattern string) *Requirement {
+	r.VersionPattern = regexp.MustCompile(pattern)
+	return r
+}
+
+func (r *Requirement) SearchIn(dirs ...string) *Requirement {
+	r.SearchDirs = append(r.SearchDirs, dirs...)
+	return r
+}
+
+func (r *Requirement) AsOptional() *Requirement {
+	r.Optional = true
+	return r
+}
+
+type RequirementResult struct {
+	Requirement *Requirement
+	Path        string
+	Version     *Version
+	//Output of the version probe
+	VersionOutput string
+	Found         bool
+	Satisfied     bool
+	Err           error
+}
+
+// RequirementsReport holds the result of every requirement checked by CheckRequirements.
+type RequirementsReport struct {
+	Results []*RequirementResult
+}
+
+// Missing returns the results of requirements that could not be found.
+func (rr *RequirementsReport) Missing() []*RequirementResult {
+	missing := []*RequirementResult{}
+	for _, result := range rr.Results {
+		if !result.Found {
+			missing = append(missing, result)
+		}
+	}
+	return missing
+}
+
+// Outdated returns the results of requirements that were found but whose version does not satisfy the constraint or
+// could not be determined.
+func (rr *RequirementsReport) Outdated() []*RequirementResult {
+	outdated := []*RequirementResult{}
+	for _, result := range rr.Results {
+		if result.Found && !result.Satisfied {
+			outdated = append(outdated, result)
+		}
+	}
+	return outdated
+}
+
+// OK is true when every requirement that is not optional is satisfied.
+func (rr *RequirementsReport) OK() bool {
+	for _, result := range rr.Results {
+		if !result.Satisfied && !result.Requirement.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns a single error listing every unsatisfied requirement that is not optional, nil when OK.
+func (rr *RequirementsReport) Err() error {
+
+	if rr.OK() {
+		return nil
+	}
+
+	problems := []string{}
+
+	for _, result := range rr.Results {
+		if !result.Satisfied && !result.Requirement.Optional {
+			problems = append(problems, result.describe())
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Unsatisfied requirements: %s", strings.Join(problems, "; ")))
+
+}
+
+func (rr *RequirementsReport) String() string {
+	var sb strings.Builder
+	for _, result := range rr.Results {
+		status := "ok"
+		if !result.Satisfied && result.Requirement.Optional {
+			status = "optional"
+		} else if !result.Satisfied {
+			status = "FAILED"
+		}
+		sb.WriteString(fmt.Sprintf("%-8s %s\n", status, result.describe()))
+	}
+	return sb.String()
+}
+
+func (rr *RequirementResult) describe() string {
+
+	name := rr.Requirement.Name
+
+	if rr.Requirement.Constraint != "" {
+		name += " " + rr.Requirement.Constraint
+	}
+
+	switch {
+	case !rr.Found:
+		return fmt.Sprintf("%s: not found", name)
+	case rr.Err != nil:
+		return fmt.Sprintf("%s: %s (%s)", name, rr.Err.Error(), rr.Path)
+	case rr.Version != nil && !rr.Satisfied:
+		return fmt.Sprintf("%s: found %s (%s)", name, rr.Version.String(), rr.Path)
+	case rr.Version != nil:
+		return fmt.Sprintf("%s: %s (%s)", name, rr.Version.String(), rr.Path)
+	}
+
+	return fmt.Sprintf("%s: %s", name, rr.Path)
+
+}
+
+type versionProbe struct {
+	version *Version
+	output  string
+	err     error
+}
+
+// lookupCache remembers resolved binaries and their versions for the life of the process
+type lookupCache struct {
+	lock     sync.Mutex
+	paths    map[string]string
+	versions map[string]*versionProbe
+}
+
+var requirementCache = &lookupCache{
+	paths:    map[string]string{},
+	versions: map[string]*versionProbe{},
+}
+
+// ClearLookupCache forgets the binaries and versions found by Resolve, ProbeVersion and CheckRequirements.
+func (ex *execUtils) ClearLookupCache() {
+	requirementCache.lock.Lock()
+	defer requirementCache.lock.Unlock()
+	requirementCache.paths = map[string]string{}
+	requirementCache.versions = map[string]*versionProbe{}
+}
+
+// Resolve finds the executable for name in searchDirs and then PATH, names containing a path separator are only
+// checked to be executable. Results are cached, see ClearLookupCache.
+func (ex *execUtils) Resolve(name string, searchDirs ...string) (string, error) {
+
+	key := name + "\x00" + strings.Join(searchDirs, string(os.PathListSeparator))
+
+	requirementCache.lock.Lock()
+	path, ok := requirementCache.paths[key]
+	requirementCache.lock.Unlock()
+
+	if ok {
+		return path, nil
+	}
+
+	path, err := resolveBinary(name, searchDirs)
+
+	if err != nil {
+		return "", err
+	}
+
+	requirementCache.lock.Lock()
+	requirementCache.paths[key] = path
+	requirementCache.lock.Unlock()
+
+	return path, nil
+
+}
+
+func resolveBinary(name string, searchDirs []string) (string, error) {
+
+	if strings.ContainsRune(name, os.PathSeparator) || strings.ContainsRune(name, '/') {
+		return exec.LookPath(name)
+	}
+
+	for _, dir := range searchDirs {
+		//LookPath checks a path containing a separator directly, adding the executable extensions on Windows
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return path, nil
+		}
+	}
+
+	return exec.LookPath(name)
+
+}
+
+// ProbeVersion runs path with args (--version when there are none) and parses the first match of pattern (a default
+// matching 1.2.3 style versions when nil) in its STDOUT and STDERR. Results are cached by path and args.
+func (ex *execUtils) ProbeVersion(path string, pattern *regexp.Regexp, args ...string) (*Version, string, error) {
+	return ex.probeVersion(path, pattern, defaultVersionTimeout, args)
+}
+
+func (ex *execUtils) probeVersion(path string, pattern *regexp.Regexp, timeout time.Duration, args []string) (*Version, string, error) {
+
+	if len(args) == 0 {
+		args = []string{"--version"}
+	}
+
+	if pattern == nil {
+		pattern = defaultVersionPattern
+	}
+
+	key := path + "\x00" + strings.Join(args, "\x00") + "\x00" + pattern.String()
+
+	requirementCache.lock.Lock()
+	probe, ok := requirementCache.versions[key]
+	requirementCache.lock.Unlock()
+
+	if ok {
+		return probe.version, probe.output, probe.err
+	}
+
+	probe = ex.runVersionProbe(path, pattern, timeout, args)
+
+	requirementCache.lock.Lock()
+	requirementCache.versions[key] = probe
+	requirementCache.lock.Unlock()
+
+	return probe.version, probe.output, probe.err
+
+}
+
+func (ex *execUtils) runVersionProbe(path string, pattern *regexp.Regexp, timeout time.Duration, args []string) *versionProbe {
+
+	ec := ex.CreateAsyncCommand(path, false, args...).CaptureStdoutAndStdErr(false, false)
+
+	if err := ec.Start(); err != nil {
+		return &versionProbe{err: err}
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- ec.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var waitErr error
+
+	select {
+	case waitErr = <-done:
+	case <-timer.C:
+		ec.Signal(os.Kill)
+		<-done
+		return &versionProbe{err: errors.New(fmt.Sprintf("Version probe timed out after %s", timeout))}
+	}
+
+	output := strings.TrimSpace(string(ec.GetStdoutBuffer()) + "\n" + string(ec.GetStderrBuffer()))
+
+	probe := &versionProbe{
+		output: output,
+	}
+
+	raw := ""
+
+	if m := pattern.FindStringSubmatch(output); len(m) > 1 {
+		raw = m[1]
+	} else if m != nil {
+		raw = m[0]
+	}
+
+	//some tools exit with an error for their version flag, the error is only ignored when they clearly printed a
+	//version rather than e.g. a number in an error message
+	if waitErr != nil && !strongVersionPattern.MatchString(raw) {
+		probe.err = errors.New(fmt.Sprintf("Version probe failed: %s", waitErr.Error()))
+		return probe
+	} else if raw == "" {
+		probe.err = errors.New(fmt.Sprintf("No version found in output %q", output))
+		return probe
+	}
+
+	probe.version, probe.err = ParseVersion(raw)
+
+	return probe
+
+}
+
+// CheckRequirements resolves every requirement, probing versions where a constraint is given, and reports all that
+// are missing or outdated at once. In dry run mode binaries are resolved but versions are not probed.
+func (ex *execUtils) CheckRequirements(requirements ...*Requirement) *RequirementsReport {
+
+	report := &RequirementsReport{
+		Results: make([]*RequirementResult, len(requirements)),
+	}
+
+	var wg sync.WaitGroup
+
+	for i, requirement := range requirements {
+		wg.Add(1)
+		go func(i int, requirement *Requirement) {
+			defer wg.Done()
+			report.Results[i] = ex.checkRequirement(requirement)
+		}(i, requirement)
+	}
+
+	wg.Wait()
+
+	return report
+
+}
+
+func (ex *execUtils) checkRequirement(requirement *Requirement) *RequirementResult {
+
+	result := &RequirementResult{
+		Requirement: requirement,
+	}
+
+	path, err := ex.Resolve(requirement.Name, requirement.SearchDirs...)
+
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Path = path
+	result.Found = true
+
+	if requirement.Constraint == "" || ex.IsDryRun() {
+		result.Satisfied = true
+		return result
+	}
+
+	constraint, err := ParseVersionConstraint(requirement.Constraint)
+
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	timeout := requirement.Timeout
+
+	if timeout <= 0 {
+		timeout = defaultVersionTimeout
+	}
+
+	result.Version, result.VersionOutput, result.Err = ex.probeVersion(path, requirement.VersionPattern, timeout, requirement.VersionArgs)
+
+	if result.Err == nil {
+		result.Satisfied = constraint.Check(result.Version)
+	}
+
+	return result
+
+}
@@ -0,0 +1,211 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+type RlimitResource int
+
+const (
+	//RlimitAddressSpace limits the virtual memory of the process in bytes (RLIMIT_AS)
+	RlimitAddressSpace RlimitResource = iota
+	//RlimitOpenFiles limits the number of open file descriptors (RLIMIT_NOFILE)
+	RlimitOpenFiles
+	//RlimitCPU limits the CPU time of the process in seconds (RLIMIT_CPU)
+	RlimitCPU
+	//RlimitCore limits the size of core dumps in bytes, 0 disables them (RLIMIT_CORE)
+	RlimitCore
+)
+
+const RlimitUnlimited = ^uint64(0)
+
+func (rr RlimitResource) String() string {
+	switch rr {
+	case RlimitAddressSpace:
+		return "as"
+	case RlimitOpenFiles:
+		return "nofile"
+	case RlimitCPU:
+		return "cpu"
+	case RlimitCore:
+		return "core"
+	}
+	return fmt.Sprintf("rlimit(%d)", int(rr))
+}
+
+type Rlimit struct {
+	Resource RlimitResource
+	//Limit is applied as both the soft and hard limit
+	Limit uint64
+}
+
+// CgroupLimits describe a transient cgroup v2 group the command is started in. Zero values leave a limit unset.
+type CgroupLimits struct {
+	//Parent is the cgroup the transient group is created under, either relative to the cgroup2 mount or as an absolute
+	//path within it. It is required and must be delegated to us without processes of its own, cgroup v2 does not let
+	//controllers be enabled below a group with processes so the cgroup of this process cannot be used.
+	Parent string
+	//MemoryMax in bytes (memory.max)
+	MemoryMax int64
+	//CPUMax in CPUs, 1.5 allows one and a half CPUs worth of time every period (cpu.max)
+	CPUMax float64
+	//PidsMax limits the number of tasks in the group (pids.max)
+	PidsMax int64
+}
+
+type ResourceLimits struct {
+	Rlimits []Rlimit
+	Cgroup  *CgroupLimits
+}
+
+// ResourceResult is what was learned about the resource usage of a command run in a transient cgroup.
+type ResourceResult struct {
+	Cgroup string
+	//OOMKills is the number of tasks the kernel killed for exceeding MemoryMax
+	OOMKills   int
+	MemoryPeak int64
+}
+
+func (rr *ResourceResult) OOMKilled() bool {
+	return rr.OOMKills > 0
+}
+
+// ResourceReporter is implemented by an ExecProcess that can report the ResourceResult of a command once Wait has
+// returned.
+type ResourceReporter interface {
+	ResourceResult() *ResourceResult
+}
+
+// SetRlimit applies a resource limit in the child before the command itself is executed.
+func (ec *ExecAsyncCommand) SetRlimit(resource RlimitResource, limit uint64) *ExecAsyncCommand {
+	if ec.resources == nil {
+		ec.resources = &ResourceLimits{}
+	}
+	for i, rl := range ec.resources.Rlimits {
+		if rl.Resource == resource {
+			ec.resources.Rlimits[i].Limit = limit
+			return ec
+		}
+	}
+	ec.resources.Rlimits = append(ec.resources.Rlimits, Rlimit{
+		Resource: resource,
+		Limit:    limit,
+	})
+	return ec
+}
+
+// SetCgroupLimits runs the command in a transient cgroup v2 group with the given limits, the group is removed once the
+// command has exited. Starting the command fails if cgroup v2 or the required controllers are not available.
+func (ec *ExecAsyncCommand) SetCgroupLimits(limits *CgroupLimits) *ExecAsyncCommand {
+	if ec.resources == nil {
+		ec.resources = &ResourceLimits{}
+	}
+	ec.resources.Cgroup = limits
+	return ec
+}
+
+// ResourceResult returns the outcome of running in a transient cgroup, it is nil until Wait has returned or when the
+// command was not run with cgroup limits.
+func (ec *ExecAsyncCommand) ResourceResult() *ResourceResult {
+	if reporter, ok := ec.process().(ResourceReporter); ok {
+		return reporter.ResourceResult()
+	}
+	return nil
+}
+
+// OOMKilled reports whether the command (or anything in its cgroup) was killed for running out of memory.
+func (ec *ExecAsyncCommand) OOMKilled() bool {
+	rr := ec.ResourceResult()
+	return rr != nil && rr.OOMKilled()
+}
+
+// rlimitScript renders the ulimit calls setting the limits in a POSIX shell, ulimit takes -v in KiB and -c in 512 byte
+// blocks in sh, dash, bash (in POSIX mode) and busybox alike
+func rlimitScript(rlimits []Rlimit) (string, error) {
+
+	script := ""
+
+	for _, rl := range rlimits {
+
+		var flag string
+		unit := uint64(1)
+
+		switch rl.Resource {
+		case RlimitAddressSpace:
+			flag, unit = "-v", 1024
+		case RlimitOpenFiles:
+			flag = "-n"
+		case RlimitCPU:
+			flag = "-t"
+		case RlimitCore:
+			flag, unit = "-c", 512
+		default:
+			return "", errors.New(fmt.Sprintf("Unsupported resource limit %s", rl.Resource.String()))
+		}
+
+		value := "unlimited"
+
+		if rl.Limit != RlimitUnlimited {
+			value = fmt.Sprintf("%d", rl.Limit/unit)
+		}
+
+		script += fmt.Sprintf("ulimit %s %s && ", flag, value)
+
+	}
+
+	return script + `exec "$@"`, nil
+
+}
+
+func (le *localExecutor) applyResources(spec *CommandSpec, cmd *exec.Cmd) (*transientCgroup, error) {
+
+	if spec.Resources == nil {
+		return nil, nil
+	}
+
+	if len(spec.Resources.Rlimits) > 0 {
+		if err := applyRlimits(cmd, spec.Resources.Rlimits); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Resources.Cgroup == nil {
+		return nil, nil
+	}
+
+	cg, err := createTransientCgroup(spec.Resources.Cgroup)
+
+	if err != nil {
+		return nil, err
+	} else if err := cg.attach(cmd); err != nil {
+		cg.remove()
+		return nil, err
+	}
+
+	return cg, nil
+
+}
+
+func (lp *localProcess) ResourceResult() *ResourceResult {
+	return lp.resources
+}
+
+// finishCgroup collects the result of a command from its transient cgroup and removes the group
+func (lp *localProcess) finishCgroup() {
+	if lp.cgroup == nil {
+		return
+	}
+	lp.resources = lp.cgroup.result()
+	if lp.resources.OOMKilled() {
+		logWarn("Command was killed for running out of memory", LogF("command", lp.cmd.Path), LogF("pid", lp.cmd.Process.Pid), LogF("cgroup", lp.resources.Cgroup))
+	}
+	if err := lp.cgroup.remove(); err != nil && !os.IsNotExist(err) {
+		logWarn("Unable to remove transient cgroup", LogF("cgroup", lp.cgroup.path), LogF("error", err))
+	}
+	lp.cgroup = nil
+}
@@ -0,0 +1,23 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"os/exec"
+)
+
+// applyRlimits wraps the command in /bin/sh which sets the limits with ulimit and then execs the command itself
+func applyRlimits(cmd *exec.Cmd, rlimits []Rlimit) error {
+
+	script, err := rlimitScript(rlimits)
+
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+
+	return nil
+
+}
@@ -0,0 +1,12 @@
+// +build !js,windows
+
+package vutils
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func applyRlimits(cmd *exec.Cmd, rlimits []Rlimit) error {
+	return errors.New("Resource limits are not supported on Windows")
+}
@@ -0,0 +1,284 @@
+// +build !js
+
+package vutils
+
+import (
+	"math"
+	"math/rand"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether a failed command is run again and how long to wait before doing so.
+//
+// With no ExitCodes, StderrPattern or Retryable set any non-zero exit is retried. Commands that could not be started or
+// were killed by a signal are only retried when Retryable says so.
+type RetryPolicy struct {
+	//MaxAttempts includes the first run, values below 1 mean a single attempt
+	MaxAttempts int
+	//InitialDelay is the delay before the second attempt, every further delay is multiplied by Multiplier (default 2)
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	//Jitter randomises each delay by up to this fraction of it in either direction, 0.2 gives +/- 20%
+	Jitter float64
+	//ExitCodes that are worth retrying
+	ExitCodes []int
+	//StderrPattern is matched against the tail of the STDERR of the failed attempt
+	StderrPattern *regexp.Regexp
+	//Retryable replaces the ExitCodes and StderrPattern checks when set
+	Retryable func(attempt *RetryAttempt) bool
+	//OnAttempt is called once each attempt has finished, before any delay
+	OnAttempt func(attempt *RetryAttempt)
+}
+
+// NewRetryPolicy returns a policy making up to maxAttempts attempts with an exponential backoff starting at one
+// second, capped at thirty seconds, with 20% jitter.
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:  maxAttempts,
+		InitialDelay: time.Second,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+func (rp *RetryPolicy) OnExitCodes(codes ...int) *RetryPolicy {
+	rp.ExitCodes = append(rp.ExitCodes, codes...)
+	return rp
+}
+
+func (rp *RetryPolicy) OnStderr(pattern string) *RetryPolicy {
+	rp.StderrPattern = regexp.MustCompile(pattern)
+	return rp
+}
+
+func (rp *RetryPolicy) retryable(attempt *RetryAttempt) bool {
+
+	if rp.Retryable != nil {
+		return rp.Retryable(attempt)
+	} else if attempt.ExitCode < 0 {
+		return false
+	} else if len(rp.ExitCodes) == 0 && rp.StderrPattern == nil {
+		return true
+	}
+
+	for _, code := range rp.ExitCodes {
+		if code == attempt.ExitCode {
+			return true
+		}
+	}
+
+	return rp.StderrPattern != nil && rp.StderrPattern.Match(attempt.Stderr)
+
+}
+
+// Delay returns the backoff to wait after the given (1 based) failed attempt.
+func (rp *RetryPolicy) Delay(attempt int) time.Duration {
+
+	multiplier := rp.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(rp.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+
+	if rp.MaxDelay > 0 && delay > float64(rp.MaxDelay) {
+		delay = float64(rp.MaxDelay)
+	}
+
+	if rp.Jitter > 0 {
+		delay += delay * rp.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return time.Duration(delay)
+
+}
+
+type RetryAttempt struct {
+	Attempt  int
+	Started  time.Time
+	Duration time.Duration
+	//ExitCode is -1 when the command could not be started or was killed by a signal
+	ExitCode int
+	Err      error
+	//Stderr holds the last stderrTailSize bytes of STDERR read during the attempt
+	Stderr []byte
+	//Retried is set when another attempt followed this one after Delay
+	Retried bool
+	Delay   time.Duration
+}
+
+// Retry runs the command again whenever it fails in a way the policy considers retryable, nil disables retries.
+//
+// Retries happen within Wait (and so StartAndWait). Output goroutines set up with BindToStdoutAndStdErr or
+// CaptureStdoutAndStdErr are reattached to each attempt and captured buffers only hold the output of the last one.
+// STDIN is not replayed, anything sent with Write or BindToStdin only reaches the first attempt.
+func (ec *ExecAsyncCommand) Retry(policy *RetryPolicy) *ExecAsyncCommand {
+	ec.retry = policy
+	return ec
+}
+
+// Attempts returns the result of every attempt made so far when a retry policy is set.
+func (ec *ExecAsyncCommand) Attempts() []*RetryAttempt {
+	return append([]*RetryAttempt{}, ec.attempts...)
+}
+
+// shouldRetry records the outcome of the current attempt and reports whether the policy wants another one
+func (ec *ExecAsyncCommand) shouldRetry(err error) bool {
+
+	if ec.retry == nil {
+		return false
+	}
+
+	attempt := &RetryAttempt{
+		Attempt:  len(ec.attempts) + 1,
+		Started:  ec.attemptStart,
+		Duration: time.Since(ec.attemptStart),
+		ExitCode: ec.ExitCode(),
+		Err:      err,
+		Stderr:   ec.stderrTail.Bytes(),
+	}
+
+	if err != nil && attempt.ExitCode == 0 {
+		attempt.ExitCode = -1
+	}
+
+	ec.attempts = append(ec.attempts, attempt)
+
+	if err != nil && attempt.Attempt < ec.retry.MaxAttempts && ec.retry.retryable(attempt) {
+		attempt.Retried = true
+		attempt.Delay = ec.retry.Delay(attempt.Attempt)
+	}
+
+	if ec.retry.OnAttempt != nil {
+		ec.retry.OnAttempt(attempt)
+	}
+
+	if attempt.Retried {
+		logWarn("Command failed, retrying", LogF("command", ec.path), LogF("attempt", attempt.Attempt), LogF("exitCode", attempt.ExitCode), LogF("delay", attempt.Delay), LogF("error", err))
+	} else if err != nil && attempt.Attempt > 1 {
+		logError("Command failed, giving up", LogF("command", ec.path), LogF("attempts", attempt.Attempt), LogF("exitCode", attempt.ExitCode), LogF("error", err))
+	}
+
+	return attempt.Retried
+
+}
+
+// restart waits out the backoff of the last attempt then runs the command again on fresh pipes
+func (ec *ExecAsyncCommand) restart() error {
+
+	time.Sleep(ec.attempts[len(ec.attempts)-1].Delay)
+
+	logInfo("Retrying command", LogF("command", ec.path), LogF("attempt", len(ec.attempts)+1))
+
+	return ec.startAgain()
+
+}
+
+// startAgain runs a command that has exited again on fresh pipes, reattaching its output goroutines
+func (ec *ExecAsyncCommand) startAgain() error {
+
+	ec.init()
+	ec.setProcess(nil)
+
+	for _, attach := range ec.outputAttachers {
+		attach()
+	}
+
+	ec.prepareProc()
+
+	return ec.startProc()
+
+}
+
+// retryLoop keeps running the command while its outcome err is deemed retryable
+func (ec *ExecAsyncCommand) retryLoop(err error) error {
+	for ec.shouldRetry(err) {
+		if err = ec.restart(); err == nil {
+			err = ec.waitProc()
+		}
+	}
+	return err
+}
+
+type retryExecUtils struct {
+	ex     *execUtils
+	policy *RetryPolicy
+}
+
+// WithRetry gives access to the command helpers with every command they run retried according to policy.
+//
+//	err := vutils.Exec.WithRetry(vutils.NewRetryPolicy(5).OnExitCodes(128)).RunCommandShowStdErr("git", "fetch")
+func (ex *execUtils) WithRetry(policy *RetryPolicy) *retryExecUtils {
+	return &retryExecUtils{
+		ex:     ex,
+		policy: policy,
+	}
+}
+
+func (re *retryExecUtils) CreateAsyncCommand(path string, errOnly bool, args ...string) *ExecAsyncCommand {
+	return re.ex.CreateAsyncCommand(path, errOnly, args...).Retry(re.policy)
+}
+
+func (re *retryExecUtils) ExecCommandShowStdErr(path string, args ...string) (*exec.Cmd, error) {
+	return re.ex.execCommandShowStdErr(re.policy, path, args...)
+}
+
+func (re *retryExecUtils) ExecCommandShowStdErrReturnOutput(path string, args ...string) (string, error) {
+	return re.ex.execCommandShowStdErrReturnOutput(re.policy, path, args...)
+}
+
+func (re *retryExecUtils) RunCommandShowStdErr(path string, args ...string) error {
+	_, err := re.ex.execCommandShowStdErr(re.policy, path, args...)
+	return err
+}
+
+func (re *retryExecUtils) RunCommandAsyncOutput(path string, errOnly bool, args ...string) error {
+	return re.ex.runCommandAsyncOutput(re.policy, path, errOnly, args...)
+}
+
+const stderrTailSize = 64 * 1024
+
+// tailBuffer keeps the last max bytes written to it, it is safe for concurrent use
+type tailBuffer struct {
+	lock sync.Mutex
+	max  int
+	buf  []byte
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{
+		max: max,
+	}
+}
+
+func (tb *tailBuffer) Write(p []byte) (int, error) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if len(p) >= tb.max {
+		tb.buf = append(tb.buf[:0], p[len(p)-tb.max:]...)
+		return len(p), nil
+	}
+	if over := len(tb.buf) + len(p) - tb.max; over > 0 {
+		tb.buf = append(tb.buf[:0], tb.buf[over:]...)
+	}
+	tb.buf = append(tb.buf, p...)
+	return len(p), nil
+}
+
+func (tb *tailBuffer) Bytes() []byte {
+	if tb == nil {
+		return nil
+	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return append([]byte{}, tb.buf...)
+}
@@ -0,0 +1,86 @@
+// +build !js
+
+package vutils
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first", &RetryPolicy{InitialDelay: time.Second, Multiplier: 2}, 1, time.Second},
+		{"doubles", &RetryPolicy{InitialDelay: time.Second, Multiplier: 2}, 4, 8 * time.Second},
+		{"default multiplier", &RetryPolicy{InitialDelay: time.Second}, 3, 4 * time.Second},
+		{"multiplier", &RetryPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 3}, 3, 900 * time.Millisecond},
+		{"capped", &RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}, 10, 5 * time.Second},
+		{"no delay", &RetryPolicy{}, 5, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Delay(tt.attempt); got != tt.want {
+			t.Errorf("%s: Delay(%d) = %s, want %s", tt.name, tt.attempt, got, tt.want)
+		}
+	}
+
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+
+	policy := &RetryPolicy{InitialDelay: time.Second, MaxDelay: 4 * time.Second, Multiplier: 2, Jitter: 0.2}
+
+	for attempt, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 5: 4 * time.Second} {
+		for i := 0; i < 100; i++ {
+			got := policy.Delay(attempt)
+			if got < base*8/10 || got > base*12/10 {
+				t.Fatalf("Delay(%d) = %s, want %s +/- 20%%", attempt, got, base)
+			}
+		}
+	}
+
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		attempt *RetryAttempt
+		want    bool
+	}{
+		{"any exit", &RetryPolicy{}, &RetryAttempt{ExitCode: 1}, true},
+		{"not started", &RetryPolicy{}, &RetryAttempt{ExitCode: -1}, false},
+		{"listed exit code", (&RetryPolicy{}).OnExitCodes(75, 111), &RetryAttempt{ExitCode: 111}, true},
+		{"other exit code", (&RetryPolicy{}).OnExitCodes(75, 111), &RetryAttempt{ExitCode: 1}, false},
+		{"stderr matches", (&RetryPolicy{}).OnStderr("(?i)timeout"), &RetryAttempt{ExitCode: 1, Stderr: []byte("connect: Timeout")}, true},
+		{"stderr does not match", (&RetryPolicy{}).OnStderr("(?i)timeout"), &RetryAttempt{ExitCode: 1, Stderr: []byte("permission denied")}, false},
+		{"exit code or stderr", &RetryPolicy{ExitCodes: []int{75}, StderrPattern: regexp.MustCompile("busy")}, &RetryAttempt{ExitCode: 1, Stderr: []byte("device busy")}, true},
+		{"signal with stderr", (&RetryPolicy{}).OnStderr("busy"), &RetryAttempt{ExitCode: -1, Stderr: []byte("busy")}, false},
+		{"retryable replaces checks", &RetryPolicy{
+			ExitCodes: []int{1},
+			Retryable: func(attempt *RetryAttempt) bool {
+				return attempt.ExitCode == -1
+			},
+		}, &RetryAttempt{ExitCode: -1}, true},
+		{"retryable refuses", &RetryPolicy{
+			ExitCodes: []int{1},
+			Retryable: func(attempt *RetryAttempt) bool {
+				return false
+			},
+		}, &RetryAttempt{ExitCode: 1}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.retryable(tt.attempt); got != tt.want {
+			t.Errorf("%s: retryable = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+}
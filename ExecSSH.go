@@ -0,0 +1,407 @@
+// +build !js
+
+package vutils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHExecutor is an Executor that runs commands on a remote host over SSH, so the same ExecAsyncCommand code (capture,
+// decoding, retries, batches) can run locally or remotely. Install it with Exec.SetExecutor or on a single command with
+// SetExecutor, or create commands bound to it with CreateAsyncCommand.
+//
+// The remote host needs a POSIX /bin/sh. The variables the command sets or changes are added to the remote
+// environment (the rest of the local environment is not sent), its working directory is changed to on the remote host
+// and signals are delivered with kill. Resource limits, namespaces and the credential privilege backend are not
+// supported. Sudo, or the sudo and doas privilege backends of a command, escalate on the remote host, set the executor
+// before escalating a command so nothing is checked on this host.
+type SSHExecutor struct {
+	client *ssh.Client
+	owned  bool
+	//Sudo runs every command through sudo on the remote host. SudoPassword validates the credentials of sudo before
+	//the command is run with sudo -n, so it is never seen by the command. sudo must not require a password otherwise.
+	Sudo         bool
+	SudoUser     string
+	SudoPassword string
+}
+
+// sshPrivilege is how a command is escalated on the remote host
+type sshPrivilege struct {
+	//tool is sudo or doas, it is run with -n
+	tool string
+	user string
+	//password is written as the first line of STDIN, it is read by the remote shell to validate sudo
+	password string
+}
+
+// argv returns what comes before the command, e.g. sudo -n -u postgres --, signals are sent as root as sudo relays
+// them from its own process
+func (sp *sshPrivilege) argv(asUser bool) []string {
+
+	argv := []string{sp.tool, "-n"}
+
+	if asUser && sp.user != "" {
+		argv = append(argv, "-u", sp.user)
+	}
+
+	return append(argv, "--")
+
+}
+
+// sudoValidation prefixes script with validating the credentials of sudo using the password on the first line of
+// STDIN. The commands run with sudo -n by script have to be children of this shell, sudo may cache the credentials
+// for its parent only.
+func sudoValidation(script string) string {
+	return `IFS= read -r p || exit 1; printf '%s\n' "$p" | sudo -S -p '' -v 2>/dev/null || { echo 'sudo rejected the password' >&2; exit 1; }; unset p; ` + script + `; exit $?`
+}
+
+// NewSSHExecutor runs commands over client, which stays open when the executor is closed.
+func NewSSHExecutor(client *ssh.Client) *SSHExecutor {
+	return &SSHExecutor{
+		client: client,
+	}
+}
+
+// CreateSSHExecutor connects as CreateSSHClient does and returns an executor that closes the connection with it.
+func (su *sshUtils) CreateSSHExecutor(host string, port string, user string, privateKeyPath string, callback ssh.HostKeyCallback) (*SSHExecutor, error) {
+
+	client, err := su.CreateSSHClient(host, port, user, privateKeyPath, callback)
+
+	if err != nil {
+		return nil, err
+	}
+
+	se := NewSSHExecutor(client)
+	se.owned = true
+
+	return se, nil
+
+}
+
+func (se *SSHExecutor) Client() *ssh.Client {
+	return se.client
+}
+
+func (se *SSHExecutor) Close() error {
+	if !se.owned {
+		return nil
+	}
+	return se.client.Close()
+}
+
+// CreateAsyncCommand creates a command that runs on the remote host.
+func (se *SSHExecutor) CreateAsyncCommand(path string, errOnly bool, args ...string) *ExecAsyncCommand {
+	return Exec.CreateAsyncCommand(path, errOnly, args...).SetExecutor(se)
+}
+
+func (se *SSHExecutor) runsRemotely() bool {
+	return true
+}
+
+// privilege returns how the command is escalated on the remote host, nil when it is not
+func (se *SSHExecutor) privilege(spec *CommandSpec) (*sshPrivilege, error) {
+
+	switch backend := spec.privilegeBackend.(type) {
+	case nil:
+		if !se.Sudo {
+			return nil, nil
+		}
+		return &sshPrivilege{
+			tool:     "sudo",
+			user:     se.SudoUser,
+			password: se.SudoPassword,
+		}, nil
+	case *SudoBackend:
+		return &sshPrivilege{
+			tool:     "sudo",
+			user:     backend.User,
+			password: backend.Password,
+		}, nil
+	case *DoasBackend:
+		return &sshPrivilege{
+			tool: "doas",
+			user: backend.User,
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unable to run a command over SSH with the %s privilege backend, use Sudo on the SSHExecutor", spec.Privilege))
+	}
+
+}
+
+// commandLine builds the remote command, the shell reports its pid on STDERR before it is replaced by the command
+func (se *SSHExecutor) commandLine(spec *CommandSpec, priv *sshPrivilege) string {
+
+	argv := []string{}
+
+	if priv != nil && priv.password == "" {
+		argv = append(argv, priv.argv(true)...)
+	}
+
+	//env comes after sudo so the variables survive its env_reset
+	if env := sshEnv(spec.Env); len(env) > 0 {
+		argv = append(append(argv, "env"), env...)
+	}
+
+	argv = append(append(argv, spec.Path), spec.Args...)
+
+	script := "echo $$ >&2 && "
+
+	if spec.Dir != "" {
+		script += "cd " + ShellQuote(spec.Dir) + " && "
+	}
+
+	script += "exec " + ShellJoin(argv...)
+
+	//with a password sudo runs a shell that reports the pid of the command as it is
+	if priv != nil && priv.password != "" {
+		script = sudoValidation(ShellJoin(append(priv.argv(true), "/bin/sh", "-c", script)...))
+	}
+
+	//the login shell of the remote user runs the command line, it may not be POSIX
+	return "exec /bin/sh -c " + ShellQuote(script)
+
+}
+
+// sshEnv returns the variables of env that the command sets or changes, the remote host has an environment of its
+// own so the rest of the local one is not sent
+func sshEnv(env []string) []string {
+
+	added, changed, _ := envDiff(os.Environ(), env)
+
+	overrides := []string{}
+
+	for _, values := range []map[string]string{added, changed} {
+		for key, value := range values {
+			overrides = append(overrides, key+"="+value)
+		}
+	}
+
+	sort.Strings(overrides)
+
+	return overrides
+
+}
+
+func (se *SSHExecutor) Start(spec *CommandSpec) (ExecProcess, error) {
+
+	if spec.Resources != nil || spec.Namespaces != nil {
+		spec.CloseStdio()
+		return nil, errors.New("Unable to apply resource limits or namespaces to a command run over SSH")
+	}
+
+	priv, err := se.privilege(spec)
+
+	if err != nil {
+		spec.CloseStdio()
+		return nil, err
+	}
+
+	session, err := se.client.NewSession()
+
+	if err != nil {
+		spec.CloseStdio()
+		return nil, err
+	}
+
+	sp, err := se.startSession(session, spec, priv)
+
+	if err != nil {
+		session.Close()
+		spec.CloseStdio()
+		return nil, err
+	}
+
+	return sp, nil
+
+}
+
+func (se *SSHExecutor) startSession(session *ssh.Session, spec *CommandSpec, priv *sshPrivilege) (*sshProcess, error) {
+
+	//the pipes are copied here rather than by the session as it waits for STDIN to be drained before Wait returns
+	stdin, err := session.StdinPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := session.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := session.StderrPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.Start(se.commandLine(spec, priv)); err != nil {
+		return nil, err
+	}
+
+	sp := &sshProcess{
+		executor:  se,
+		session:   session,
+		spec:      spec,
+		privilege: priv,
+		exitCode:  -1,
+	}
+
+	//the remote shell reads the password before it reports the pid
+	if priv != nil && priv.password != "" {
+		if _, err := stdin.Write([]byte(priv.password + "\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	errReader := bufio.NewReader(stderr)
+	line, err := errReader.ReadString('\n')
+
+	if sp.pid, _ = strconv.Atoi(strings.TrimSpace(line)); sp.pid <= 0 {
+		if err == nil {
+			err = errors.New(fmt.Sprintf("unexpected output %q", line))
+		} else if werr := session.Wait(); werr != nil {
+			err = werr
+		}
+		return nil, errors.New(fmt.Sprintf("Unable to start command over SSH: %s", err.Error()))
+	}
+
+	go func() {
+		if spec.Stdin != nil {
+			io.Copy(stdin, spec.Stdin)
+		}
+		stdin.Close()
+	}()
+
+	sp.copyOutput(stdout, spec.Stdout)
+	sp.copyOutput(errReader, spec.Stderr)
+
+	//readers of the command see EOF once the session has seen the end of both streams
+	go func() {
+		sp.output.Wait()
+		spec.CloseStdio()
+	}()
+
+	return sp, nil
+
+}
+
+type sshProcess struct {
+	executor  *SSHExecutor
+	session   *ssh.Session
+	spec      *CommandSpec
+	privilege *sshPrivilege
+	pid       int
+	output    sync.WaitGroup
+	exitCode  int
+}
+
+func (sp *sshProcess) copyOutput(r io.Reader, w io.Writer) {
+
+	if w == nil {
+		w = ioutil.Discard
+	}
+
+	sp.output.Add(1)
+
+	go func() {
+		defer sp.output.Done()
+		io.Copy(w, r)
+	}()
+
+}
+
+// Pid is the pid of the command on the remote host.
+func (sp *sshProcess) Pid() int {
+	return sp.pid
+}
+
+// Signal runs kill on the remote host as not every SSH server delivers signal requests.
+func (sp *sshProcess) Signal(sig os.Signal) error {
+
+	name, err := sshSignalName(sig)
+
+	if err != nil {
+		return err
+	}
+
+	session, err := sp.executor.client.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	cmd := ShellJoin("kill", "-s", name, strconv.Itoa(sp.pid))
+
+	if priv := sp.privilege; priv != nil {
+		cmd = ShellJoin(append(priv.argv(false), "kill", "-s", name, strconv.Itoa(sp.pid))...)
+		if priv.password != "" {
+			cmd = "exec /bin/sh -c " + ShellQuote(sudoValidation(cmd))
+			session.Stdin = strings.NewReader(priv.password + "\n")
+		}
+	}
+
+	if out, err := session.CombinedOutput(cmd); err != nil {
+		return errors.New(fmt.Sprintf("Unable to signal remote process %d: %s", sp.pid, strings.TrimSpace(string(out))))
+	}
+
+	return nil
+
+}
+
+func (sp *sshProcess) Wait() error {
+
+	err := sp.session.Wait()
+
+	sp.output.Wait()
+	sp.spec.CloseStdio()
+	sp.session.Close()
+
+	if err == nil {
+		sp.exitCode = 0
+	} else if exitErr, ok := err.(*ssh.ExitError); ok && exitErr.Signal() == "" {
+		sp.exitCode = exitErr.ExitStatus()
+	}
+
+	return err
+
+}
+
+func (sp *sshProcess) ExitCode() int {
+	return sp.exitCode
+}
+
+var sshSignalNames = map[os.Signal]string{
+	syscall.SIGABRT: "ABRT",
+	syscall.SIGALRM: "ALRM",
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGINT:  "INT",
+	syscall.SIGKILL: "KILL",
+	syscall.SIGPIPE: "PIPE",
+	syscall.SIGQUIT: "QUIT",
+	syscall.SIGTERM: "TERM",
+}
+
+func sshSignalName(sig os.Signal) (string, error) {
+	if name, ok := sshSignalNames[sig]; ok {
+		return name, nil
+	} else if name, ok := sshSignalNamesUnix[sig]; ok {
+		return name, nil
+	}
+	return "", errors.New(fmt.Sprintf("Unable to send signal %s over SSH", sig.String()))
+}
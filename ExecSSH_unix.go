@@ -0,0 +1,17 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"os"
+	"syscall"
+)
+
+var sshSignalNamesUnix = map[os.Signal]string{
+	syscall.SIGCONT:  "CONT",
+	syscall.SIGSTOP:  "STOP",
+	syscall.SIGTSTP:  "TSTP",
+	syscall.SIGUSR1:  "USR1",
+	syscall.SIGUSR2:  "USR2",
+	syscall.SIGWINCH: "WINCH",
+}
@@ -0,0 +1,9 @@
+// +build !js,windows
+
+package vutils
+
+import (
+	"os"
+)
+
+var sshSignalNamesUnix = map[os.Signal]string{}
@@ -0,0 +1,140 @@
+// +build !js
+
+package vutils
+
+import (
+	"os"
+	"os/signal"
+)
+
+type signalForward struct {
+	from os.Signal
+	to   os.Signal
+}
+
+type signalForwarder struct {
+	ch   chan os.Signal
+	done chan struct{}
+}
+
+// ForwardSignals relays the signals this process receives to the command for as long as it runs, e.g. SIGHUP to let
+// a wrapped daemon reload. The handlers are removed again once the command exits.
+func (ec *ExecAsyncCommand) ForwardSignals(signals ...os.Signal) *ExecAsyncCommand {
+	for _, sig := range signals {
+		ec.ForwardSignal(sig, sig)
+	}
+	return ec
+}
+
+// ForwardSignal relays from to the command as to, forwarding the same signal again replaces its target.
+func (ec *ExecAsyncCommand) ForwardSignal(from os.Signal, to os.Signal) *ExecAsyncCommand {
+	for i, forward := range ec.signalForwards {
+		if forward.from == from {
+			ec.signalForwards[i].to = to
+			return ec
+		}
+	}
+	ec.signalForwards = append(ec.signalForwards, signalForward{
+		from: from,
+		to:   to,
+	})
+	return ec
+}
+
+// ForwardWindowSize keeps the terminal of a command running with UsePTY the same size as the terminal of this process
+// (STDIN), resizing it whenever this process receives SIGWINCH. The command itself is sent SIGWINCH by the terminal.
+func (ec *ExecAsyncCommand) ForwardWindowSize() *ExecAsyncCommand {
+	ec.windowSize = true
+	return ec
+}
+
+// startForwarding registers the signal handlers once the command has started, retries keep the same handlers
+func (ec *ExecAsyncCommand) startForwarding() {
+
+	if ec.forwarder != nil {
+		return
+	}
+
+	signals := []os.Signal{}
+
+	for _, forward := range ec.signalForwards {
+		signals = append(signals, forward.from)
+	}
+
+	if ec.windowSize && ec.pty {
+		signals = append(signals, windowSizeSignals()...)
+		ec.syncWindowSize()
+	}
+
+	if len(signals) == 0 {
+		return
+	}
+
+	sf := &signalForwarder{
+		ch:   make(chan os.Signal, len(signals)),
+		done: make(chan struct{}),
+	}
+
+	signal.Notify(sf.ch, signals...)
+
+	ec.forwarder = sf
+
+	go func() {
+		for {
+			select {
+			case sig := <-sf.ch:
+				ec.forwardSignal(sig)
+			case <-sf.done:
+				return
+			}
+		}
+	}()
+
+}
+
+func (ec *ExecAsyncCommand) forwardSignal(sig os.Signal) {
+
+	if ec.windowSize && ec.pty && isWindowSizeSignal(sig) {
+		ec.syncWindowSize()
+	}
+
+	for _, forward := range ec.signalForwards {
+		if forward.from != sig {
+			continue
+		}
+		logDebug("Forwarding signal", LogF("command", ec.path), LogF("signal", sig.String()), LogF("as", forward.to.String()))
+		if err := ec.Signal(forward.to); err != nil {
+			logWarn("Unable to forward signal", LogF("command", ec.path), LogF("signal", forward.to.String()), LogF("error", err))
+		}
+		return
+	}
+
+}
+
+func (ec *ExecAsyncCommand) syncWindowSize() {
+
+	rows, cols, err := terminalSize(os.Stdin)
+
+	if err != nil {
+		logDebug("Unable to read the terminal size", LogF("error", err))
+		return
+	}
+
+	if err := ec.ResizePTY(rows, cols); err != nil {
+		logWarn("Unable to resize PTY", LogF("command", ec.path), LogF("error", err))
+	}
+
+}
+
+// stopForwarding unregisters the handlers so the signals get their default behaviour in this process again
+func (ec *ExecAsyncCommand) stopForwarding() {
+
+	if ec.forwarder == nil {
+		return
+	}
+
+	signal.Stop(ec.forwarder.ch)
+	close(ec.forwarder.done)
+	ec.forwarder = nil
+
+}
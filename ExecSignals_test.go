@@ -0,0 +1,73 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestForwardSignal(t *testing.T) {
+
+	ec := Exec.CreateAsyncCommand("/bin/sh", false, "-c", `trap 'echo forwarded; exit 0' USR1; echo ready; while :; do sleep 0.01; done`).
+		ForwardSignal(syscall.SIGUSR2, syscall.SIGUSR1)
+
+	ex, err := ec.Expect()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ex.ExpectString(5*time.Second, "ready"); err != nil {
+		t.Fatal(err)
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+
+	if _, err := ex.ExpectString(5*time.Second, "forwarded"); err != nil {
+		ec.Signal(syscall.SIGKILL)
+		t.Errorf("the signal was not forwarded: %v", err)
+	}
+
+	if err := ec.Wait(); err != nil {
+		t.Error(err)
+	}
+
+}
+
+func TestForwardSignalsDuringRetry(t *testing.T) {
+
+	ec := Exec.CreateAsyncCommand("/bin/sh", false, "-c", "exit 1").
+		Retry(&RetryPolicy{MaxAttempts: 30}).
+		ForwardSignals(syscall.SIGWINCH)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	//keep signalling while attempts are replaced, go test -race reports unsynchronised access to the process
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				syscall.Kill(syscall.Getpid(), syscall.SIGWINCH)
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	err := ec.StartAndWait()
+
+	close(done)
+	<-stopped
+
+	if err == nil || len(ec.Attempts()) != 30 {
+		t.Errorf("StartAndWait returned %v after %d attempts", err, len(ec.Attempts()))
+	}
+
+}
@@ -0,0 +1,175 @@
+// +build !js
+
+package vutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+type stdinSource struct {
+	open func() (io.Reader, error)
+	//finite sources always come to an end so Wait can wait for them to be copied
+	finite bool
+	//owned sources are closed once they have been copied
+	owned bool
+}
+
+// SetStdin feeds r to the STDIN of the command, which is closed once r is drained. Wait does not wait for r to be
+// drained and r is not rewound when the command is retried.
+func (ec *ExecAsyncCommand) SetStdin(r io.Reader) *ExecAsyncCommand {
+	return ec.setStdinSource(&stdinSource{
+		open: func() (io.Reader, error) {
+			return r, nil
+		},
+	})
+}
+
+func (ec *ExecAsyncCommand) SetStdinString(s string) *ExecAsyncCommand {
+	return ec.setStdinSource(&stdinSource{
+		open: func() (io.Reader, error) {
+			return strings.NewReader(s), nil
+		},
+		finite: true,
+	})
+}
+
+func (ec *ExecAsyncCommand) SetStdinBytes(data []byte) *ExecAsyncCommand {
+	return ec.setStdinSource(&stdinSource{
+		open: func() (io.Reader, error) {
+			return bytes.NewReader(data), nil
+		},
+		finite: true,
+	})
+}
+
+// SetStdinFile feeds the contents of the file at path to the STDIN of the command, the file is opened when the command
+// starts.
+func (ec *ExecAsyncCommand) SetStdinFile(path string) *ExecAsyncCommand {
+	return ec.setStdinSource(&stdinSource{
+		open: func() (io.Reader, error) {
+			return os.Open(path)
+		},
+		finite: true,
+		owned:  true,
+	})
+}
+
+func (ec *ExecAsyncCommand) setStdinSource(source *stdinSource) *ExecAsyncCommand {
+	if ec.stdinBound {
+		logWarn("Unable to set STDIN as it is already bound.", LogF("command", ec.path))
+		return ec
+	}
+	ec.stdin = source
+	ec.stdinBound = true
+	return ec
+}
+
+// CloseStdin closes the STDIN of the command so it sees the end of its input, for use after Write.
+func (ec *ExecAsyncCommand) CloseStdin() error {
+	if ec.stdinBound {
+		return errors.New("Unable to close STDIN as it is fed from a source, it is closed once the source is drained")
+	} else if ec.pty {
+		return errors.New("Unable to close STDIN of a command on a PTY, send ^D instead")
+	} else if ec.writer == nil {
+		return errors.New("Unable to close STDIN of a command whose STDIN has been redirected")
+	}
+	return ec.writer.Close()
+}
+
+// startStdin starts copying the source into STDIN, it is called after every start of the command
+func (ec *ExecAsyncCommand) startStdin() {
+
+	if ec.stdin == nil || ec.writer == nil {
+		return
+	}
+
+	writer := ec.writer
+	done := make(chan struct{})
+
+	ec.stdinLock.Lock()
+	ec.stdinDone, ec.stdinErr = done, nil
+	ec.stdinLock.Unlock()
+
+	go func() {
+		defer close(done)
+		err := ec.copyStdin(writer)
+		ec.stdinLock.Lock()
+		ec.stdinErr = err
+		ec.stdinLock.Unlock()
+	}()
+
+}
+
+func (ec *ExecAsyncCommand) copyStdin(w io.WriteCloser) error {
+
+	r, err := ec.stdin.open()
+
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	if c, ok := r.(io.Closer); ok && ec.stdin.owned {
+		defer c.Close()
+	}
+
+	_, err = io.Copy(w, r)
+
+	//the terminal is also the output of a command on a PTY so it stays open
+	if !ec.pty {
+		if cerr := w.Close(); err == nil && !errors.Is(cerr, os.ErrClosed) {
+			err = cerr
+		}
+	}
+
+	//the command exiting or closing STDIN before reading everything is up to the command
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+
+	return err
+
+}
+
+// stdinResult returns the error copying the source into STDIN once the command has exited
+func (ec *ExecAsyncCommand) stdinResult() error {
+
+	ec.stdinLock.Lock()
+	done := ec.stdinDone
+	ec.stdinLock.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	//nothing reads STDIN any more, closing it stops a copy blocked on a full pipe
+	if ec.writer != nil {
+		ec.writer.Close()
+	}
+
+	if ec.stdin.finite {
+		<-done
+	} else {
+		select {
+		case <-done:
+		default:
+			return nil
+		}
+	}
+
+	ec.stdinLock.Lock()
+	defer ec.stdinLock.Unlock()
+
+	if ec.stdinErr != nil {
+		return errors.New(fmt.Sprintf("Unable to feed STDIN of the command: %s", ec.stdinErr.Error()))
+	}
+
+	return nil
+
+}
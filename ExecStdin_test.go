@@ -0,0 +1,97 @@
+// +build !js,!windows
+
+package vutils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStdinSources(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vutils-stdin")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "input")
+
+	if err := ioutil.WriteFile(path, []byte("from a file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ec   *ExecAsyncCommand
+		want string
+		err  string
+	}{
+		{"string", Exec.CreateAsyncCommand("cat", false).SetStdinString("a string\n"), "a string\n", ""},
+		{"bytes", Exec.CreateAsyncCommand("cat", false).SetStdinBytes([]byte{'x', 0, 'y'}), "x\x00y", ""},
+		{"file", Exec.CreateAsyncCommand("cat", false).SetStdinFile(path), "from a file\n", ""},
+		{"reader", Exec.CreateAsyncCommand("cat", false).SetStdin(strings.NewReader("a reader")), "a reader", ""},
+		{"first source wins", Exec.CreateAsyncCommand("cat", false).SetStdinString("first").SetStdinString("second"), "first", ""},
+		{"missing file", Exec.CreateAsyncCommand("cat", false).SetStdinFile(filepath.Join(dir, "missing")), "", "Unable to feed STDIN of the command"},
+		{"input not read", Exec.CreateAsyncCommand("true", false).SetStdinBytes(bytes.Repeat([]byte("x"), 1<<20)), "", ""},
+		{"retried", shellStage("cat; [ -e " + ShellQuote(path) + ".done ] || { touch " + ShellQuote(path) + ".done; exit 1; }").SetStdinString("again").Retry(&RetryPolicy{MaxAttempts: 2}), "again", ""},
+	}
+
+	for _, tt := range tests {
+
+		tt.ec.CaptureStdoutAndStdErr(false, false)
+
+		err := tt.ec.StartAndWait()
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: StartAndWait returned %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: StartAndWait returned %s", tt.name, err)
+		} else if got := string(tt.ec.GetStdoutBuffer()); got != tt.want {
+			t.Errorf("%s: the command read %q, want %q", tt.name, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestCloseStdin(t *testing.T) {
+
+	ec := Exec.CreateAsyncCommand("cat", false).CaptureStdoutAndStdErr(false, false)
+
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	} else if err := ec.Write([]byte("written")); err != nil {
+		t.Fatal(err)
+	} else if err := ec.CloseStdin(); err != nil {
+		t.Fatal(err)
+	} else if err := ec.Wait(); err != nil {
+		t.Fatal(err)
+	} else if got := string(ec.GetStdoutBuffer()); got != "written" {
+		t.Errorf("the command read %q", got)
+	}
+
+	fed := Exec.CreateAsyncCommand("cat", false).SetStdinString("fed")
+
+	if err := fed.CloseStdin(); err == nil || !strings.Contains(err.Error(), "fed from a source") {
+		t.Errorf("CloseStdin of a fed command returned %v", err)
+	} else if err := fed.Write([]byte("x")); err == nil {
+		t.Error("Write to a fed command did not fail")
+	}
+
+	redirected := Exec.CreateAsyncCommand("cat", false)
+	redirected.redirectStdin(strings.NewReader(""), nil)
+
+	if err := redirected.CloseStdin(); err == nil || !strings.Contains(err.Error(), "has been redirected") {
+		t.Errorf("CloseStdin of a redirected command returned %v", err)
+	}
+
+}
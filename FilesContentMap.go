@@ -2,7 +2,6 @@ package vutils
 
 import (
 	"errors"
-	"fmt"
 	"github.com/bmatcuk/doublestar"
 	"os"
 	"path/filepath"
@@ -605,7 +604,7 @@ func (cm *ContentsMap) doFileCopy(destRoot string, fileItem *ContentsMapDestinat
 			fmode = info.Mode()
 		}
 		fullDestPath := cm.makeDestFilePath(destRoot, fileItem.destPath)
-		fmt.Println("Copying From", fileItem.sourcePath, "to", fileItem.destPath, "resolved dest:", fullDestPath)
+		logDebug("Copying file", LogF("path", fileItem.sourcePath), LogF("dest", fileItem.destPath), LogF("resolvedDest", fullDestPath))
 		if err := fcopyMode(fileItem.sourcePath, fullDestPath, info, fmode); err != nil {
 			return err
 		}
@@ -0,0 +1,141 @@
+// +build !js
+
+package vutils
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (ll LogLevel) String() string {
+	switch ll {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(ll))
+}
+
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+func LogF(key string, value interface{}) LogField {
+	return LogField{
+		Key:   key,
+		Value: value,
+	}
+}
+
+// Logger receives all diagnostic output of the package, by default it is discarded. Use SetLogger to install one.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(level LogLevel, msg string, fields ...LogField) {}
+
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+type stdLogger struct {
+	logger   *log.Logger
+	minLevel LogLevel
+}
+
+// NewStdLogger writes logfmt style lines (level=info msg="..." pid=123) to a standard library logger.
+func NewStdLogger(logger *log.Logger, minLevel LogLevel) Logger {
+	if logger == nil {
+		logger = log.New(log.Writer(), "", log.LstdFlags)
+	}
+	return &stdLogger{
+		logger:   logger,
+		minLevel: minLevel,
+	}
+}
+
+func (sl *stdLogger) Log(level LogLevel, msg string, fields ...LogField) {
+
+	if level < sl.minLevel {
+		return
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("level=")
+	sb.WriteString(level.String())
+	sb.WriteString(" msg=")
+	sb.WriteString(logfmtValue(msg))
+
+	for _, f := range fields {
+		sb.WriteString(" ")
+		sb.WriteString(f.Key)
+		sb.WriteString("=")
+		sb.WriteString(logfmtValue(fmt.Sprint(f.Value)))
+	}
+
+	sl.logger.Print(sb.String())
+
+}
+
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
+
+var (
+	packageLoggerLock sync.RWMutex
+	packageLogger     Logger = nopLogger{}
+)
+
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	packageLoggerLock.Lock()
+	packageLogger = logger
+	packageLoggerLock.Unlock()
+}
+
+func GetLogger() Logger {
+	packageLoggerLock.RLock()
+	defer packageLoggerLock.RUnlock()
+	return packageLogger
+}
+
+func logDebug(msg string, fields ...LogField) {
+	GetLogger().Log(LogDebug, msg, fields...)
+}
+
+func logInfo(msg string, fields ...LogField) {
+	GetLogger().Log(LogInfo, msg, fields...)
+}
+
+func logWarn(msg string, fields ...LogField) {
+	GetLogger().Log(LogWarn, msg, fields...)
+}
+
+func logError(msg string, fields ...LogField) {
+	GetLogger().Log(LogError, msg, fields...)
+}
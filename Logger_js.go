@@ -0,0 +1,19 @@
+// +build js
+
+package vutils
+
+// The Logger is not available under js, the files that are also built there drop their diagnostics.
+
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+func LogF(key string, value interface{}) LogField {
+	return LogField{
+		Key:   key,
+		Value: value,
+	}
+}
+
+func logDebug(msg string, fields ...LogField) {}
@@ -0,0 +1,51 @@
+// +build go1.21,!js
+
+package vutils
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger adapts a *slog.Logger, fields are passed through as structured attributes.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{
+		logger: logger,
+	}
+}
+
+func (sl *slogLogger) Log(level LogLevel, msg string, fields ...LogField) {
+
+	slevel := slog.LevelInfo
+
+	switch level {
+	case LogDebug:
+		slevel = slog.LevelDebug
+	case LogWarn:
+		slevel = slog.LevelWarn
+	case LogError:
+		slevel = slog.LevelError
+	}
+
+	ctx := context.Background()
+
+	if !sl.logger.Enabled(ctx, slevel) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, len(fields))
+
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+
+	sl.logger.LogAttrs(ctx, slevel, msg, attrs...)
+
+}
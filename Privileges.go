@@ -0,0 +1,263 @@
+// +build !js
+
+package vutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type Capability uint
+
+const (
+	CapChown Capability = iota
+	CapDacOverride
+	CapDacReadSearch
+	CapFowner
+	CapFsetid
+	CapKill
+	CapSetgid
+	CapSetuid
+	CapSetpcap
+	CapLinuxImmutable
+	CapNetBindService
+	CapNetBroadcast
+	CapNetAdmin
+	CapNetRaw
+	CapIpcLock
+	CapIpcOwner
+	CapSysModule
+	CapSysRawio
+	CapSysChroot
+	CapSysPtrace
+	CapSysPacct
+	CapSysAdmin
+	CapSysBoot
+	CapSysNice
+	CapSysResource
+	CapSysTime
+	CapSysTtyConfig
+	CapMknod
+	CapLease
+	CapAuditWrite
+	CapAuditControl
+	CapSetfcap
+	CapMacOverride
+	CapMacAdmin
+	CapSyslog
+	CapWakeAlarm
+	CapBlockSuspend
+	CapAuditRead
+	CapPerfmon
+	CapBpf
+	CapCheckpointRestore
+)
+
+var capabilityNames = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+func (c Capability) String() string {
+	if int(c) < len(capabilityNames) {
+		return capabilityNames[c]
+	}
+	return fmt.Sprintf("cap_%d", uint(c))
+}
+
+// CapabilitySet is a capability bitmask as found in the Cap* fields of /proc/[pid]/status.
+type CapabilitySet uint64
+
+func (cs CapabilitySet) Has(c Capability) bool {
+	return c < 64 && cs&(1<<c) != 0
+}
+
+func (cs CapabilitySet) List() []Capability {
+	caps := []Capability{}
+	for c := Capability(0); c < 64; c++ {
+		if cs.Has(c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+func (cs CapabilitySet) String() string {
+	names := []string{}
+	for _, c := range cs.List() {
+		names = append(names, c.String())
+	}
+	return strings.Join(names, ",")
+}
+
+type CapabilitySets struct {
+	Inheritable CapabilitySet
+	Permitted   CapabilitySet
+	Effective   CapabilitySet
+	Bounding    CapabilitySet
+	Ambient     CapabilitySet
+}
+
+type PrivilegeInfo struct {
+	UID    int
+	EUID   int
+	GID    int
+	EGID   int
+	Groups []int
+	//Capabilities is nil on platforms without Linux capabilities
+	Capabilities     *CapabilitySets
+	PasswordlessSudo bool
+	InUserNamespace  bool
+}
+
+func (pi *PrivilegeInfo) IsRoot() bool {
+	return pi.EUID == 0
+}
+
+func (pi *PrivilegeInfo) HasCapability(c Capability) bool {
+	if pi.IsRoot() && pi.Capabilities == nil {
+		return true
+	} else if pi.Capabilities == nil {
+		return false
+	}
+	return pi.Capabilities.Effective.Has(c)
+}
+
+// CanSetCredentials reports whether children can be started as another user and group.
+func (pi *PrivilegeInfo) CanSetCredentials() bool {
+	return pi.HasCapability(CapSetuid) && pi.HasCapability(CapSetgid)
+}
+
+func (pi *PrivilegeInfo) InGroup(gid int) bool {
+	if pi.GID == gid || pi.EGID == gid {
+		return true
+	}
+	for _, g := range pi.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+type privilegeUtils struct {
+}
+
+// Inspect gathers everything known about the privileges of this process, including whether sudo can be used without a password.
+func (pu *privilegeUtils) Inspect() (*PrivilegeInfo, error) {
+
+	info, err := pu.inspectIds()
+
+	if err != nil {
+		return nil, err
+	}
+
+	info.PasswordlessSudo = pu.PasswordlessSudo()
+
+	return info, nil
+
+}
+
+func (pu *privilegeUtils) inspectIds() (*PrivilegeInfo, error) {
+
+	info := &PrivilegeInfo{
+		UID:    os.Getuid(),
+		EUID:   os.Geteuid(),
+		GID:    os.Getgid(),
+		EGID:   os.Getegid(),
+		Groups: []int{},
+	}
+
+	if groups, err := os.Getgroups(); err == nil {
+		info.Groups = groups
+	}
+
+	caps, err := readCapabilitySets()
+
+	if err != nil {
+		return nil, err
+	}
+
+	info.Capabilities = caps
+	info.InUserNamespace = inUserNamespace()
+
+	return info, nil
+
+}
+
+func (pu *privilegeUtils) IsRoot() bool {
+	return os.Geteuid() == 0
+}
+
+func (pu *privilegeUtils) HasCapability(c Capability) bool {
+	info, err := pu.inspectIds()
+	if err != nil {
+		return false
+	}
+	return info.HasCapability(c)
+}
+
+func (pu *privilegeUtils) CanSetCredentials() bool {
+	info, err := pu.inspectIds()
+	if err != nil {
+		return false
+	}
+	return info.CanSetCredentials()
+}
+
+func (pu *privilegeUtils) PasswordlessSudo() bool {
+
+	if _, err := exec.LookPath("sudo"); err != nil {
+		return false
+	}
+
+	return exec.Command("sudo", "-n", "true").Run() == nil
+
+}
+
+func (pu *privilegeUtils) InUserNamespace() bool {
+	return inUserNamespace()
+}
+
+var Privileges = &privilegeUtils{}
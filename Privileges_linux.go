@@ -0,0 +1,88 @@
+// +build !js,linux
+
+package vutils
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func readCapabilitySets() (*CapabilitySets, error) {
+
+	f, err := os.Open("/proc/self/status")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	caps := &CapabilitySets{}
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		var set *CapabilitySet
+
+		switch parts[0] {
+		case "CapInh":
+			set = &caps.Inheritable
+		case "CapPrm":
+			set = &caps.Permitted
+		case "CapEff":
+			set = &caps.Effective
+		case "CapBnd":
+			set = &caps.Bounding
+		case "CapAmb":
+			set = &caps.Ambient
+		default:
+			continue
+		}
+
+		mask, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 16, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		*set = CapabilitySet(mask)
+
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return caps, nil
+
+}
+
+func inUserNamespace() bool {
+
+	//the initial user namespace maps the full uid range onto itself
+
+	data, err := ioutil.ReadFile("/proc/self/uid_map")
+
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(data))
+
+	if len(fields) != 3 {
+		return true
+	}
+
+	return !(fields[0] == "0" && fields[1] == "0" && fields[2] == "4294967295")
+
+}
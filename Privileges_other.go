@@ -0,0 +1,15 @@
+// +build !js,!linux
+
+package vutils
+
+func readCapabilitySets() (*CapabilitySets, error) {
+
+	return nil, nil
+
+}
+
+func inUserNamespace() bool {
+
+	return false
+
+}
@@ -0,0 +1,264 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ShellQuote escapes s so a POSIX shell reads it back as a single word with exactly this value.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./-_", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// ShellJoin quotes every element of argv and joins them into a command line.
+func ShellJoin(argv ...string) string {
+	quoted := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		quoted = append(quoted, ShellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// ShellCommand builds a POSIX sh command line, arguments are always quoted and only the operators added through its
+// methods (or Raw) are left for the shell to interpret.
+//
+//	vutils.NewShellCommand("grep", "-r", userInput, ".").Pipe("wc", "-l").RedirectOut("/tmp/count").String()
+type ShellCommand struct {
+	parts []string
+}
+
+func NewShellCommand(argv ...string) *ShellCommand {
+	return (&ShellCommand{}).Arg(argv...)
+}
+
+func (sc *ShellCommand) Arg(args ...string) *ShellCommand {
+	for _, arg := range args {
+		sc.parts = append(sc.parts, ShellQuote(arg))
+	}
+	return sc
+}
+
+// Raw adds fragment as is, it must never contain untrusted input.
+func (sc *ShellCommand) Raw(fragment string) *ShellCommand {
+	sc.parts = append(sc.parts, fragment)
+	return sc
+}
+
+// Env prefixes the next command with a variable assignment.
+func (sc *ShellCommand) Env(key string, value string) *ShellCommand {
+	sc.parts = append(sc.parts, key+"="+ShellQuote(value))
+	return sc
+}
+
+func (sc *ShellCommand) Pipe(argv ...string) *ShellCommand {
+	return sc.Raw("|").Arg(argv...)
+}
+
+func (sc *ShellCommand) And(argv ...string) *ShellCommand {
+	return sc.Raw("&&").Arg(argv...)
+}
+
+func (sc *ShellCommand) Or(argv ...string) *ShellCommand {
+	return sc.Raw("||").Arg(argv...)
+}
+
+func (sc *ShellCommand) Then(argv ...string) *ShellCommand {
+	return sc.Raw(";").Arg(argv...)
+}
+
+func (sc *ShellCommand) RedirectIn(path string) *ShellCommand {
+	return sc.Raw("<").Arg(path)
+}
+
+func (sc *ShellCommand) RedirectOut(path string) *ShellCommand {
+	return sc.Raw(">").Arg(path)
+}
+
+func (sc *ShellCommand) AppendOut(path string) *ShellCommand {
+	return sc.Raw(">>").Arg(path)
+}
+
+func (sc *ShellCommand) RedirectErr(path string) *ShellCommand {
+	return sc.Raw("2>").Arg(path)
+}
+
+func (sc *ShellCommand) StderrToStdout() *ShellCommand {
+	return sc.Raw("2>&1")
+}
+
+func (sc *ShellCommand) String() string {
+	return strings.Join(sc.parts, " ")
+}
+
+// ShellSplit splits a shell-like string into argv without running a shell. Single and double quotes and backslash
+// escapes are handled as sh would, nothing is expanded and operators such as | or ; are ordinary characters.
+func ShellSplit(s string) ([]string, error) {
+
+	args := []string{}
+	var word strings.Builder
+	inWord := false
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); i++ {
+
+		r := runes[i]
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, errors.New("Unterminated escape at the end of the string")
+			}
+			i++
+			//a backslash newline is a line continuation
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errors.New("Unterminated single quote")
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				//within double quotes backslash only escapes $ ` " \ and newline
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("Unterminated double quote")
+			}
+			inWord = true
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+
+	}
+
+	if inWord {
+		args = append(args, word.String())
+	}
+
+	return args, nil
+
+}
+
+// DefaultShells is the order shells are looked for in, $SHELL stands for the login shell of the user.
+var DefaultShells = []string{"$SHELL", "sh", "bash", "busybox"}
+
+var nonPosixShells = []string{"fish", "csh", "tcsh", "nu", "xonsh", "pwsh", "elvish"}
+
+type ShellInfo struct {
+	Path string
+	Name string
+	//Args come before -c, busybox needs to be told to act as sh
+	Args []string
+}
+
+// Command returns the path and arguments that run script with this shell.
+func (si *ShellInfo) Command(script string, args ...string) (string, []string) {
+	cmdArgs := append(append([]string{}, si.Args...), "-c", script)
+	if len(args) > 0 {
+		cmdArgs = append(append(cmdArgs, "sh"), args...)
+	}
+	return si.Path, cmdArgs
+}
+
+// FindShell returns the first of the candidates (DefaultShells when none are given) that is installed. Candidates are
+// names looked up in PATH, absolute paths or $SHELL. Shells that do not understand POSIX sh syntax are skipped.
+func FindShell(candidates ...string) (*ShellInfo, error) {
+
+	if len(candidates) == 0 {
+		candidates = DefaultShells
+	}
+
+	for _, candidate := range candidates {
+
+		if candidate == "$SHELL" {
+			candidate = os.Getenv("SHELL")
+		}
+
+		if candidate == "" {
+			continue
+		}
+
+		name := filepath.Base(candidate)
+
+		if containsString(nonPosixShells, name) {
+			continue
+		}
+
+		path, err := exec.LookPath(candidate)
+
+		if err != nil {
+			continue
+		}
+
+		si := &ShellInfo{
+			Path: path,
+			Name: name,
+		}
+
+		if name == "busybox" {
+			si.Args = []string{"sh"}
+		}
+
+		return si, nil
+
+	}
+
+	return nil, errors.New("Unable to find a shell, tried " + strings.Join(candidates, ", "))
+
+}
+
+// CreateShellCommand runs script with the first shell found, see FindShell.
+func (ex *execUtils) CreateShellCommand(script string, errOnly bool, shells ...string) (*ExecAsyncCommand, error) {
+
+	shell, err := FindShell(shells...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	path, args := shell.Command(script)
+
+	return ex.CreateAsyncCommand(path, errOnly, args...), nil
+
+}
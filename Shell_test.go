@@ -0,0 +1,99 @@
+// +build !js
+
+package vutils
+
+import (
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"/usr/bin/env", "/usr/bin/env"},
+		{"KEY=value,a:b@c%d+e", "KEY=value,a:b@c%d+e"},
+		{"two words", "'two words'"},
+		{"it's", `'it'"'"'s'`},
+		{"$HOME", "'$HOME'"},
+		{"a;b|c&d", "'a;b|c&d'"},
+		{"line\nbreak", "'line\nbreak'"},
+		{"*", "'*'"},
+	}
+
+	for _, tt := range tests {
+		if got := ShellQuote(tt.in); got != tt.want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+}
+
+func TestShellSplit(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{in: "", want: []string{}},
+		{in: "  \t\n ", want: []string{}},
+		{in: "ls -la /tmp", want: []string{"ls", "-la", "/tmp"}},
+		{in: "  a   b  ", want: []string{"a", "b"}},
+		{in: `echo 'single $quoted' "double quoted"`, want: []string{"echo", "single $quoted", "double quoted"}},
+		{in: `a''b ""`, want: []string{"ab", ""}},
+		{in: `escaped\ space`, want: []string{"escaped space"}},
+		{in: "line\\\ncontinued", want: []string{"linecontinued"}},
+		{in: `"a \"b\" \$c \\ \d"`, want: []string{`a "b" $c \ \d`}},
+		{in: `'no \escapes'`, want: []string{`no \escapes`}},
+		{in: `a|b; c`, want: []string{"a|b;", "c"}},
+		{in: `"unterminated`, err: true},
+		{in: `'unterminated`, err: true},
+		{in: `trailing\`, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ShellSplit(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ShellSplit(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ShellSplit(%q) returned %s", tt.in, err)
+		} else if !equalStrings(got, tt.want) {
+			t.Errorf("ShellSplit(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+}
+
+func TestShellQuoteRoundTrip(t *testing.T) {
+
+	argv := []string{"", "plain", "two words", "it's", `"quoted"`, `back\slash`, "$(rm -rf /)", "tab\there", "new\nline"}
+
+	got, err := ShellSplit(ShellJoin(argv...))
+
+	if err != nil {
+		t.Fatal(err)
+	} else if !equalStrings(got, argv) {
+		t.Errorf("ShellSplit(ShellJoin(%q)) = %q", argv, got)
+	}
+
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
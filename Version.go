@@ -0,0 +1,315 @@
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, versions with fewer than three components are accepted and padded with zeros.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+	//parts is the number of numeric components that were given
+	parts int
+}
+
+var versionPattern = regexp.MustCompile(`^[vV]?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+func ParseVersion(s string) (*Version, error) {
+
+	m := versionPattern.FindStringSubmatch(strings.TrimSpace(s))
+
+	if m == nil {
+		return nil, errors.New(fmt.Sprintf("Invalid version %q", s))
+	}
+
+	v := &Version{
+		Prerelease: m[4],
+		Build:      m[5],
+	}
+
+	for i, field := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] == "" {
+			break
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid version %q: %s", s, err.Error()))
+		}
+		*field = n
+		v.parts++
+	}
+
+	return v, nil
+
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 following semver precedence, build metadata is ignored.
+func (v *Version) Compare(other *Version) int {
+
+	for _, d := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if d[0] != d[1] {
+			if d[0] < d[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	//a prerelease sorts before the release itself
+	if v.Prerelease == other.Prerelease {
+		return 0
+	} else if v.Prerelease == "" {
+		return 1
+	} else if other.Prerelease == "" {
+		return -1
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(other.Prerelease, ".")
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseIdentifier(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+
+	if len(a) < len(b) {
+		return -1
+	} else if len(a) > len(b) {
+		return 1
+	}
+
+	return 0
+
+}
+
+func comparePrereleaseIdentifier(a string, b string) int {
+
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		if an < bn {
+			return -1
+		} else if an > bn {
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		//numeric identifiers have lower precedence than alphanumeric ones
+		return -1
+	case bErr == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+
+}
+
+type versionComparator struct {
+	op      string
+	version *Version
+	//upper turns != into a range, versions from version up to but not including upper are excluded
+	upper *Version
+}
+
+func (vc versionComparator) check(v *Version) bool {
+	c := v.Compare(vc.version)
+	if vc.op == "!=" && vc.upper != nil {
+		return c < 0 || v.Compare(vc.upper) >= 0
+	}
+	switch vc.op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "!=":
+		return c != 0
+	}
+	return c == 0
+}
+
+func (vc versionComparator) String() string {
+	if vc.op == "!=" && vc.upper != nil {
+		return "<" + vc.version.String() + " || >=" + vc.upper.String()
+	}
+	return vc.op + vc.version.String()
+}
+
+// VersionConstraint is a set of alternatives separated by || where each alternative is a list of comparisons that must
+// all hold, separated by commas or spaces. The operators are =, !=, >, >=, <, <=, ~ (patch updates, ~1.2 allows
+// 1.2.x) and ^ (updates that do not change the left-most non-zero component). Partial versions and wildcards match
+// ranges, 1.2, 1.2.x and 1.2.* all allow any 1.2 release, !=1.2 excludes all of them and * allows everything.
+type VersionConstraint struct {
+	original     string
+	alternatives [][]versionComparator
+}
+
+var constraintTermPattern = regexp.MustCompile(`(!=|>=|<=|==|=|>|<|~|\^)?\s*([^\s,|<>=!~^]+)`)
+
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+
+	vc := &VersionConstraint{
+		original: s,
+	}
+
+	for _, alternative := range strings.Split(s, "||") {
+
+		comparators := []versionComparator{}
+		rest := strings.TrimSpace(alternative)
+
+		for rest != "" {
+
+			loc := constraintTermPattern.FindStringSubmatchIndex(rest)
+
+			if loc == nil || strings.Trim(rest[:loc[0]], " ,") != "" {
+				return nil, errors.New(fmt.Sprintf("Invalid version constraint %q", s))
+			}
+
+			op, version := "", rest[loc[4]:loc[5]]
+			if loc[2] >= 0 {
+				op = rest[loc[2]:loc[3]]
+			}
+
+			terms, err := expandConstraintTerm(op, version)
+
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid version constraint %q: %s", s, err.Error()))
+			}
+
+			comparators = append(comparators, terms...)
+			rest = strings.TrimLeft(rest[loc[1]:], " ,")
+
+		}
+
+		if len(comparators) == 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid version constraint %q: empty alternative", s))
+		}
+
+		vc.alternatives = append(vc.alternatives, comparators)
+
+	}
+
+	return vc, nil
+
+}
+
+// expandConstraintTerm turns a single operator and version into plain comparisons
+func expandConstraintTerm(op string, version string) ([]versionComparator, error) {
+
+	//wildcards are the same as leaving the component out
+	trimmed := version
+	for _, wildcard := range []string{".x", ".X", ".*"} {
+		trimmed = strings.Replace(trimmed, wildcard, "", -1)
+	}
+
+	if trimmed == "*" || trimmed == "x" || trimmed == "X" {
+		return []versionComparator{{op: ">=", version: &Version{}}}, nil
+	}
+
+	v, err := ParseVersion(trimmed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//upper is the first version outside of the range a partial version, ~ or ^ stands for, prereleases of the next
+	//version are outside of it as well so <2 does not allow 2.0.0-rc1
+	upper := func(component int) *Version {
+		switch component {
+		case 0:
+			return &Version{Major: v.Major + 1, Prerelease: "0"}
+		case 1:
+			return &Version{Major: v.Major, Minor: v.Minor + 1, Prerelease: "0"}
+		}
+		return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Prerelease: "0"}
+	}
+
+	lower := &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, Prerelease: v.Prerelease}
+
+	switch op {
+	case "~":
+		component := 1
+		if v.parts == 1 {
+			component = 0
+		}
+		return []versionComparator{{op: ">=", version: lower}, {op: "<", version: upper(component)}}, nil
+	case "^":
+		component := 0
+		if v.Major == 0 && v.parts > 1 {
+			component = 1
+			if v.Minor == 0 && v.parts > 2 {
+				component = 2
+			}
+		}
+		return []versionComparator{{op: ">=", version: lower}, {op: "<", version: upper(component)}}, nil
+	case "", "=", "==":
+		if v.parts < 3 {
+			return []versionComparator{{op: ">=", version: lower}, {op: "<", version: upper(v.parts - 1)}}, nil
+		}
+		return []versionComparator{{op: "=", version: v}}, nil
+	case "!=":
+		//!=1.2 excludes every 1.2 release
+		if v.parts < 3 {
+			return []versionComparator{{op: "!=", version: lower, upper: upper(v.parts - 1)}}, nil
+		}
+	case ">":
+		//>1.2 means above every 1.2 release
+		if v.parts < 3 {
+			return []versionComparator{{op: ">=", version: upper(v.parts - 1)}}, nil
+		}
+	case "<":
+		if v.parts < 3 && v.Prerelease == "" {
+			return []versionComparator{{op: "<", version: &Version{Major: v.Major, Minor: v.Minor, Prerelease: "0"}}}, nil
+		}
+	case "<=":
+		if v.parts < 3 {
+			return []versionComparator{{op: "<", version: upper(v.parts - 1)}}, nil
+		}
+	}
+
+	return []versionComparator{{op: op, version: v}}, nil
+
+}
+
+func (vc *VersionConstraint) Check(v *Version) bool {
+	for _, alternative := range vc.alternatives {
+		ok := true
+		for _, comparator := range alternative {
+			if !comparator.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (vc *VersionConstraint) String() string {
+	return vc.original
+}
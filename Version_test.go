@@ -0,0 +1,190 @@
+package vutils
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		want  string
+		parts int
+		err   bool
+	}{
+		{in: "1.2.3", want: "1.2.3", parts: 3},
+		{in: "v1.2.3", want: "1.2.3", parts: 3},
+		{in: " V10.20.30 ", want: "10.20.30", parts: 3},
+		{in: "1.2", want: "1.2.0", parts: 2},
+		{in: "7", want: "7.0.0", parts: 1},
+		{in: "1.2.3-rc.1", want: "1.2.3-rc.1", parts: 3},
+		{in: "1.2.3+build.5", want: "1.2.3+build.5", parts: 3},
+		{in: "1.2.3-beta+exp.sha.5114f85", want: "1.2.3-beta+exp.sha.5114f85", parts: 3},
+		{in: "", err: true},
+		{in: "1.2.3.4", err: true},
+		{in: "one.two", err: true},
+		{in: "1..2", err: true},
+		{in: "1.2.3-", err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) = %s, want an error", tt.in, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q) returned %s", tt.in, err)
+			continue
+		}
+		if v.String() != tt.want || v.parts != tt.parts {
+			t.Errorf("ParseVersion(%q) = %s with %d parts, want %s with %d parts", tt.in, v, v.parts, tt.want, tt.parts)
+		}
+	}
+
+}
+
+func TestVersionCompare(t *testing.T) {
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.3.0", "1.2.9", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3+a", "1.2.3+b", 0},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		//the precedence example from the semver spec
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.1", 0},
+	}
+
+	for _, tt := range tests {
+		a, b := mustParseVersion(t, tt.a), mustParseVersion(t, tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Compare(a); got != -tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+
+}
+
+func TestExpandConstraintTerm(t *testing.T) {
+
+	tests := []struct {
+		op, version string
+		want        []string
+		err         bool
+	}{
+		{op: "", version: "*", want: []string{">=0.0.0"}},
+		{op: "", version: "1.x", want: []string{">=1.0.0", "<2.0.0-0"}},
+		{op: "", version: "1.2.*", want: []string{">=1.2.0", "<1.3.0-0"}},
+		{op: "=", version: "1.2", want: []string{">=1.2.0", "<1.3.0-0"}},
+		{op: "==", version: "1.2.3", want: []string{"=1.2.3"}},
+		{op: "~", version: "1.2.3", want: []string{">=1.2.3", "<1.3.0-0"}},
+		{op: "~", version: "1", want: []string{">=1.0.0", "<2.0.0-0"}},
+		{op: "^", version: "1.2.3", want: []string{">=1.2.3", "<2.0.0-0"}},
+		{op: "^", version: "0.2.3", want: []string{">=0.2.3", "<0.3.0-0"}},
+		{op: "^", version: "0.0.3", want: []string{">=0.0.3", "<0.0.4-0"}},
+		{op: "^", version: "0.0", want: []string{">=0.0.0", "<0.1.0-0"}},
+		{op: ">", version: "1.2", want: []string{">=1.3.0-0"}},
+		{op: ">", version: "1.2.3", want: []string{">1.2.3"}},
+		{op: "<", version: "2", want: []string{"<2.0.0-0"}},
+		{op: "<", version: "2.0.0-rc1", want: []string{"<2.0.0-rc1"}},
+		{op: "<=", version: "1.2", want: []string{"<1.3.0-0"}},
+		{op: ">=", version: "1.2", want: []string{">=1.2.0"}},
+		{op: "!=", version: "1.2.3", want: []string{"!=1.2.3"}},
+		{op: "!=", version: "1.2", want: []string{"<1.2.0 || >=1.3.0-0"}},
+		{op: "!=", version: "1.x", want: []string{"<1.0.0 || >=2.0.0-0"}},
+		{op: "~", version: "latest", err: true},
+	}
+
+	for _, tt := range tests {
+		comparators, err := expandConstraintTerm(tt.op, tt.version)
+		if tt.err {
+			if err == nil {
+				t.Errorf("expandConstraintTerm(%q, %q) did not return an error", tt.op, tt.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandConstraintTerm(%q, %q) returned %s", tt.op, tt.version, err)
+			continue
+		}
+		got := []string{}
+		for _, comparator := range comparators {
+			got = append(got, comparator.String())
+		}
+		if !equalStrings(got, tt.want) {
+			t.Errorf("expandConstraintTerm(%q, %q) = %v, want %v", tt.op, tt.version, got, tt.want)
+		}
+	}
+
+}
+
+func TestVersionConstraintCheck(t *testing.T) {
+
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "2.0.0", false},
+		{"^1.2", "2.0.0-rc1", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{">=1.2, <2", "1.5.0", true},
+		{">=1.2 <2", "2.0.0", false},
+		{"1.2.x || >=3", "3.1.0", true},
+		{"1.2.x || >=3", "2.0.0", false},
+		{"!=1.2.3", "1.2.3", false},
+		{"!=1.2.3", "1.2.4", true},
+		{"!=1.2", "1.2.0", false},
+		{"!=1.2", "1.2.9", false},
+		{"!=1.2", "1.1.9", true},
+		{"!=1.2", "1.3.0", true},
+		{">=1, !=1.2", "1.2.5", false},
+		{"*", "0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		vc, err := ParseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseVersionConstraint(%q) returned %s", tt.constraint, err)
+			continue
+		}
+		if got := vc.Check(mustParseVersion(t, tt.version)); got != tt.want {
+			t.Errorf("%q.Check(%s) = %t, want %t", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=1.2 ||", "=> 1.2", "1.2 ~"} {
+		if _, err := ParseVersionConstraint(invalid); err == nil {
+			t.Errorf("ParseVersionConstraint(%q) did not return an error", invalid)
+		}
+	}
+
+}
+
+func mustParseVersion(t *testing.T, s string) *Version {
+	t.Helper()
+	v, err := ParseVersion(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -3,13 +3,12 @@
 package vutils
 
 import (
-	"context"
 	"errors"
-	"golang.org/x/sync/errgroup"
-	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type ProcessManager struct {
@@ -18,8 +17,23 @@ type ProcessManager struct {
 	CaptureSignal bool
 	ExitOnSignal  bool
 	OnExit        func()
-	exitChan      chan os.Signal
-	cleaningUp    bool
+	//Shells are the candidates for Shell and ExecScript, see FindShell. When empty bash is preferred, then $SHELL, sh
+	//and busybox
+	Shells []string
+	//Restart is the policy for processes whose options do not have one, nil never restarts them
+	Restart *RestartPolicy
+	//StopOptions is the stop sequence for processes whose options do not have one, nil sends SIGINT, waits 10 seconds
+	//and sends SIGKILL
+	StopOptions *StopOptions
+	//ShutdownTimeout is the deadline for Shutdown to stop every process, 0 leaves it to the stop sequences
+	ShutdownTimeout time.Duration
+	exitChan        chan os.Signal
+	cleaningUp      bool
+	lock            sync.Mutex
+	//definitions are started by StartAll into named, started keeps the order they were started in
+	definitions []*ProcessDefinition
+	named       map[string]*ProcessManagerProcess
+	started     []string
 }
 
 func (pm *ProcessManager) init() {
@@ -28,18 +42,18 @@ func (pm *ProcessManager) init() {
 
 		//if we are forcing a clean exit we need to signal all child processes and do the cleanup
 
-		pm.exitChan = make(chan os.Signal)
-		signal.Notify(pm.exitChan, os.Interrupt, syscall.SIGTERM, syscall.SIGKILL)
+		//SIGKILL cannot be caught, the channel is buffered so a signal is not lost while nothing receives it
+		pm.exitChan = make(chan os.Signal, 1)
+		signal.Notify(pm.exitChan, os.Interrupt, syscall.SIGTERM)
 		go func() {
 			<-pm.exitChan
 			if !pm.CaptureSignal {
 				return
 			}
-			log.Print("Killing all processes")
+			logInfo("Killing all processes", LogF("count", len(pm.processes())))
 			err := pm.signalTermAllProcesses()
 			if err != nil {
-				log.Print("Error killing all processes")
-				log.Print(err)
+				logError("Error killing all processes", LogF("error", err))
 			}
 
 			if pm.OnExit != nil {
@@ -59,46 +73,44 @@ func (pm *ProcessManager) init() {
 
 func (pm *ProcessManager) signalTermAllProcesses() error {
 
-	g, _ := errgroup.WithContext(context.Background())
-
-	for pid, proc := range pm.processMap {
-
-		g.Go(func() error {
-
-			if err := proc.Signal(syscall.SIGINT); err != nil {
-				//return nil as we want all items to complete killing
-				log.Printf("Error sending signal to process %d", pid)
-			} else if err := proc.Wait(); err != nil {
-				log.Printf("Error waiting for process %d to exit on cleanup.", pid)
-			} else {
-				log.Printf("Successfully killed %d", pid)
-			}
-
-			return nil
-
-		})
+	report := pm.Shutdown()
 
+	for _, result := range report.Processes {
+		if result.Err != nil {
+			logError("Error stopping process", LogF("pid", result.Pid), LogF("command", result.Command), LogF("error", result.Err))
+		} else {
+			logInfo("Successfully stopped process", LogF("pid", result.Pid), LogF("command", result.Command), LogF("signals", len(result.Signals)))
+		}
 	}
 
-	return g.Wait()
+	return report.Err()
 
 }
 
 func (pm *ProcessManager) addProcessToMap(proc *ProcessManagerProcess) error {
 
-	if _, ok := pm.processMap[proc.execProc.Proc.Process.Pid]; ok {
+	if proc.execProc.IsDryRun() {
+		//nothing is running so there is nothing to manage
+		return nil
+	}
+
+	pm.lock.Lock()
+
+	if _, ok := pm.processMap[proc.Pid()]; ok {
 
+		pm.lock.Unlock()
 		return errors.New("Unable to add process to process manager as it already exists")
 
 	}
 
-	pm.processMap[proc.execProc.Proc.Process.Pid] = proc
+	pm.processMap[proc.Pid()] = proc
+
+	pm.lock.Unlock()
 
 	go func() {
 		err := proc.Wait()
 		if err != nil {
-			log.Print("Error Waiting for Process to finish")
-			log.Print(err)
+			logError("Error Waiting for Process to finish", LogF("pid", proc.Pid()), LogF("command", proc.execProc.path), LogF("error", err))
 		}
 		if !pm.cleaningUp {
 			pm.removeProcessFromMap(proc)
@@ -111,27 +123,92 @@ func (pm *ProcessManager) addProcessToMap(proc *ProcessManagerProcess) error {
 
 func (pm *ProcessManager) removeProcessFromMap(proc *ProcessManagerProcess) error {
 
-	if _, ok := pm.processMap[proc.execProc.Proc.Process.Pid]; !ok {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	pid, ok := pm.mappedPid(proc)
+
+	if !ok {
 
 		return errors.New("Unable to remove process from process manager as it doesnt exist")
 
 	}
 
-	delete(pm.processMap, proc.execProc.Proc.Process.Pid)
+	delete(pm.processMap, pid)
 
 	return nil
 
 }
 
+// mappedPid finds the pid proc was added under, which is not its current one when a restart failed to start
+func (pm *ProcessManager) mappedPid(proc *ProcessManagerProcess) (int, bool) {
+	for pid, mapped := range pm.processMap {
+		if mapped == proc {
+			return pid, true
+		}
+	}
+	return 0, false
+}
+
+// updateProcessPid moves a restarted process to its new pid
+func (pm *ProcessManager) updateProcessPid(proc *ProcessManagerProcess) {
+
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if pid, ok := pm.mappedPid(proc); ok {
+		delete(pm.processMap, pid)
+		pm.processMap[proc.Pid()] = proc
+	}
+
+}
+
+// processes returns a snapshot of the managed processes by pid
+func (pm *ProcessManager) processes() map[int]*ProcessManagerProcess {
+
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	procs := make(map[int]*ProcessManagerProcess, len(pm.processMap))
+
+	for pid, proc := range pm.processMap {
+		procs[pid] = proc
+	}
+
+	return procs
+
+}
+
 func (pm *ProcessManager) SignIntAll() error {
 
 	return pm.signalTermAllProcesses()
 
 }
 
+func (pm *ProcessManager) findShell() (*ShellInfo, error) {
+
+	if len(pm.Shells) > 0 {
+		return FindShell(pm.Shells...)
+	}
+
+	//commands and scripts have always been run with bash so prefer it when it is installed
+	return FindShell("bash", "$SHELL", "sh", "busybox")
+
+}
+
+// Shell runs cmd with the shell found from Shells, build cmd with NewShellCommand or ShellJoin when it includes
+// untrusted input.
 func (pm *ProcessManager) Shell(cmd string, options *ProcessManagerProcessOptions) (*ProcessManagerProcess, error) {
 
-	if proc, err := pm.RunAsync("/bin/bash", options, "-c", cmd); err != nil {
+	shell, err := pm.findShell()
+
+	if err != nil {
+		return nil, err
+	}
+
+	path, args := shell.Command(cmd)
+
+	if proc, err := pm.RunAsync(path, options, args...); err != nil {
 		return nil, err
 	} else {
 		return proc, nil
@@ -140,15 +217,29 @@ func (pm *ProcessManager) Shell(cmd string, options *ProcessManagerProcessOption
 }
 func (pm *ProcessManager) ShellWait(cmd string, options *ProcessManagerProcessOptions) error {
 
-	return pm.RunAsyncWait("/bin/bash", options, "-c", cmd)
+	shell, err := pm.findShell()
+
+	if err != nil {
+		return err
+	}
+
+	path, args := shell.Command(cmd)
+
+	return pm.RunAsyncWait(path, options, args...)
 
 }
 
-func (pm *ProcessManager) ExecScript(scriptPath string, options *ProcessManagerProcessOptions, cmdArgs ...string) (*ProcessManagerProcess, error) {
+func (pm *ProcessManager) scriptCommand(scriptPath string, cmdArgs []string) (string, []string, error) {
+
+	shell, err := pm.findShell()
+
+	if err != nil {
+		return "", nil, err
+	}
 
-	nargs := make([]string, 1)
+	nargs := append([]string{}, shell.Args...)
 
-	nargs[0] = scriptPath
+	nargs = append(nargs, scriptPath)
 
 	if len(cmdArgs) > 0 {
 
@@ -156,7 +247,19 @@ func (pm *ProcessManager) ExecScript(scriptPath string, options *ProcessManagerP
 
 	}
 
-	if proc, err := pm.RunAsync("/bin/bash", options, nargs...); err != nil {
+	return shell.Path, nargs, nil
+
+}
+
+func (pm *ProcessManager) ExecScript(scriptPath string, options *ProcessManagerProcessOptions, cmdArgs ...string) (*ProcessManagerProcess, error) {
+
+	path, nargs, err := pm.scriptCommand(scriptPath, cmdArgs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if proc, err := pm.RunAsync(path, options, nargs...); err != nil {
 		return nil, err
 	} else {
 		return proc, nil
@@ -165,17 +268,13 @@ func (pm *ProcessManager) ExecScript(scriptPath string, options *ProcessManagerP
 }
 func (pm *ProcessManager) ExecScriptWait(scriptPath string, options *ProcessManagerProcessOptions, cmdArgs ...string) error {
 
-	nargs := make([]string, 1, 1)
-
-	nargs[0] = scriptPath
-
-	if len(cmdArgs) > 0 {
-
-		nargs = append(nargs, cmdArgs...)
+	path, nargs, err := pm.scriptCommand(scriptPath, cmdArgs)
 
+	if err != nil {
+		return err
 	}
 
-	return pm.RunAsyncWait("/bin/bash", options, nargs...)
+	return pm.RunAsyncWait(path, options, nargs...)
 
 }
 
@@ -218,15 +317,25 @@ func (pm *ProcessManager) RunAsyncWait(binary string, options *ProcessManagerPro
 
 }
 func (pm *ProcessManager) RunExec(pr *ExecAsyncCommand) (*ProcessManagerProcess, error) {
+	return pm.runExec(pr, nil)
+}
 
-	options, err := Processes.DefaultProcessOptions()
+func (pm *ProcessManager) runExec(pr *ExecAsyncCommand, options *ProcessManagerProcessOptions) (*ProcessManagerProcess, error) {
 
-	if err != nil {
-		return nil, err
-	}
+	if options == nil {
+
+		nopts, err := Processes.DefaultProcessOptions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !pr.errOnly {
+			nopts.OutputStdOut = true
+		}
+
+		options = nopts
 
-	if !pr.errOnly {
-		options.OutputStdOut = true
 	}
 
 	proc := newProcManProcessFromExec(pm, options, pr)
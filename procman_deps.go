@@ -0,0 +1,319 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type DependencyCondition int
+
+const (
+	//DependsStarted is met once the dependency has been started
+	DependsStarted DependencyCondition = iota
+	//DependsHealthy is met once the dependency is running and its probes pass, see HealthStatus.Healthy
+	DependsHealthy
+	//DependsCompleted is met once the dependency has exited successfully, e.g. a migration
+	DependsCompleted
+)
+
+func (dc DependencyCondition) String() string {
+	switch dc {
+	case DependsHealthy:
+		return "healthy"
+	case DependsCompleted:
+		return "completed successfully"
+	default:
+		return "started"
+	}
+}
+
+const defaultDependencyTimeout = 5 * time.Minute
+
+// ProcessDefinition is a named process that StartAll starts once the processes it depends on meet their conditions.
+type ProcessDefinition struct {
+	Name    string
+	Binary  string
+	Args    []string
+	Options *ProcessManagerProcessOptions
+	//Command is run instead of Binary when set
+	Command *ExecAsyncCommand
+	//DependencyTimeout bounds the wait for the dependencies to meet their conditions, 5 minutes by default
+	DependencyTimeout time.Duration
+	dependsOn         []processDependency
+}
+
+type processDependency struct {
+	name      string
+	condition DependencyCondition
+}
+
+// Define adds a named process for StartAll, redefining a name replaces it.
+func (pm *ProcessManager) Define(name string, binary string, options *ProcessManagerProcessOptions, args ...string) *ProcessDefinition {
+	return pm.define(&ProcessDefinition{
+		Name:    name,
+		Binary:  binary,
+		Args:    args,
+		Options: options,
+	})
+}
+
+// DefineExec adds a named process for StartAll that runs cmd, options nil uses the same defaults as RunExec.
+func (pm *ProcessManager) DefineExec(name string, cmd *ExecAsyncCommand, options *ProcessManagerProcessOptions) *ProcessDefinition {
+	return pm.define(&ProcessDefinition{
+		Name:    name,
+		Command: cmd,
+		Options: options,
+	})
+}
+
+func (pm *ProcessManager) define(def *ProcessDefinition) *ProcessDefinition {
+
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	for i, existing := range pm.definitions {
+		if existing.Name == def.Name {
+			pm.definitions[i] = def
+			return def
+		}
+	}
+
+	pm.definitions = append(pm.definitions, def)
+
+	return def
+
+}
+
+// DependsOn makes the process wait for name to meet condition before it is started.
+func (pd *ProcessDefinition) DependsOn(name string, condition DependencyCondition) *ProcessDefinition {
+	pd.dependsOn = append(pd.dependsOn, processDependency{
+		name:      name,
+		condition: condition,
+	})
+	return pd
+}
+
+// Process returns the process started for a definition by StartAll, nil when it has not been started.
+func (pm *ProcessManager) Process(name string) *ProcessManagerProcess {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	return pm.named[name]
+}
+
+// startOrder sorts the definitions so every process comes after its dependencies, keeping the order they were defined
+// in otherwise
+func (pm *ProcessManager) startOrder() ([]*ProcessDefinition, error) {
+
+	pm.lock.Lock()
+	definitions := append([]*ProcessDefinition{}, pm.definitions...)
+	pm.lock.Unlock()
+
+	byName := map[string]*ProcessDefinition{}
+
+	for _, def := range definitions {
+		byName[def.Name] = def
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	order := []*ProcessDefinition{}
+	path := []string{}
+
+	var visit func(def *ProcessDefinition) error
+
+	visit = func(def *ProcessDefinition) error {
+
+		switch state[def.Name] {
+		case visited:
+			return nil
+		case visiting:
+			//the cycle is the part of the path from the first visit of this process
+			for i, name := range path {
+				if name == def.Name {
+					return errors.New(fmt.Sprintf("Dependency cycle between processes: %s -> %s", strings.Join(path[i:], " -> "), def.Name))
+				}
+			}
+		}
+
+		state[def.Name] = visiting
+		path = append(path, def.Name)
+
+		for _, dep := range def.dependsOn {
+			depDef, ok := byName[dep.name]
+			if !ok {
+				return errors.New(fmt.Sprintf("Process %s depends on %s which is not defined", def.Name, dep.name))
+			}
+			if err := visit(depDef); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[def.Name] = visited
+		order = append(order, def)
+
+		return nil
+
+	}
+
+	for _, def := range definitions {
+		if err := visit(def); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+
+}
+
+// StartAll starts every defined process in dependency order, waiting for the dependencies of each to meet their
+// conditions first. When a process cannot be started or a dependency fails, the processes already started are stopped
+// again in reverse order.
+func (pm *ProcessManager) StartAll() error {
+
+	order, err := pm.startOrder()
+
+	if err != nil {
+		return err
+	}
+
+	for _, def := range order {
+
+		if pm.Process(def.Name) != nil {
+			continue
+		}
+
+		if err := pm.waitForDependencies(def); err != nil {
+			logError("Unable to start process", LogF("name", def.Name), LogF("error", err))
+			pm.StopAll(syscall.SIGTERM)
+			return err
+		}
+
+		proc, err := pm.startDefinition(def)
+
+		if err != nil {
+			pm.StopAll(syscall.SIGTERM)
+			return errors.New(fmt.Sprintf("Unable to start process %s: %s", def.Name, err.Error()))
+		}
+
+		logInfo("Started process", LogF("name", def.Name), LogF("pid", proc.Pid()))
+
+		pm.lock.Lock()
+		if pm.named == nil {
+			pm.named = map[string]*ProcessManagerProcess{}
+		}
+		pm.named[def.Name] = proc
+		proc.name = def.Name
+		pm.started = append(pm.started, def.Name)
+		pm.lock.Unlock()
+
+	}
+
+	return nil
+
+}
+
+// startDefinition starts def with a copy of its options, starting a process may change them (LogStdOut sets
+// OutputStdOut) and they can be shared with other definitions
+func (pm *ProcessManager) startDefinition(def *ProcessDefinition) (*ProcessManagerProcess, error) {
+	var options *ProcessManagerProcessOptions
+	if def.Options != nil {
+		copied := *def.Options
+		options = &copied
+	}
+	if def.Command != nil {
+		return pm.runExec(def.Command, options)
+	}
+	return pm.RunAsync(def.Binary, options, def.Args...)
+}
+
+func (pm *ProcessManager) waitForDependencies(def *ProcessDefinition) error {
+
+	timeout := def.DependencyTimeout
+
+	if timeout <= 0 {
+		timeout = defaultDependencyTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for _, dep := range def.dependsOn {
+
+		proc := pm.Process(dep.name)
+
+		if proc == nil {
+			return errors.New(fmt.Sprintf("Process %s depends on %s which has not been started", def.Name, dep.name))
+		}
+
+		if dep.condition != DependsStarted {
+			logInfo("Waiting for dependency", LogF("name", def.Name), LogF("dependency", dep.name), LogF("condition", dep.condition.String()))
+		}
+
+		if err := proc.waitForCondition(dep.condition, deadline); err != nil {
+			return errors.New(fmt.Sprintf("Process %s depends on %s being %s: %s", def.Name, dep.name, dep.condition.String(), err.Error()))
+		}
+
+	}
+
+	return nil
+
+}
+
+func (pmp *ProcessManagerProcess) waitForCondition(condition DependencyCondition, deadline time.Time) error {
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	switch condition {
+	case DependsCompleted:
+		select {
+		case <-pmp.exited:
+			return pmp.exitError()
+		case <-timer.C:
+			return errors.New("timed out waiting for it to exit")
+		}
+	case DependsHealthy:
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for !pmp.Health().Healthy() {
+			select {
+			case <-pmp.exited:
+				if err := pmp.exitError(); err != nil {
+					return errors.New(fmt.Sprintf("it exited: %s", err.Error()))
+				}
+				return errors.New("it exited")
+			case <-timer.C:
+				return errors.New("timed out waiting for it to become healthy")
+			case <-ticker.C:
+			}
+		}
+	}
+
+	return nil
+
+}
+
+// StopAll stops the processes started by StartAll in the reverse of the order they were started in, waiting for each
+// to exit before stopping the next. Each is sent signal, then escalated as its stop sequence says.
+func (pm *ProcessManager) StopAll(signal os.Signal) error {
+
+	report := &ShutdownReport{
+		Started: time.Now(),
+	}
+
+	pm.stopNamed(signal, time.Time{}, report)
+
+	return report.Err()
+
+}
@@ -0,0 +1,146 @@
+// +build !js
+
+package vutils
+
+import (
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStartOrder(t *testing.T) {
+
+	type dependency struct {
+		from, to string
+	}
+
+	tests := []struct {
+		name  string
+		procs []string
+		deps  []dependency
+		want  []string
+		err   string
+	}{
+		{
+			name:  "definition order",
+			procs: []string{"a", "b", "c"},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "dependencies first",
+			procs: []string{"api", "worker", "db", "cache"},
+			deps:  []dependency{{"api", "db"}, {"api", "cache"}, {"worker", "api"}},
+			want:  []string{"db", "cache", "api", "worker"},
+		},
+		{
+			name:  "shared dependency once",
+			procs: []string{"a", "b", "shared"},
+			deps:  []dependency{{"a", "shared"}, {"b", "shared"}},
+			want:  []string{"shared", "a", "b"},
+		},
+		{
+			name:  "missing dependency",
+			procs: []string{"api"},
+			deps:  []dependency{{"api", "db"}},
+			err:   "Process api depends on db which is not defined",
+		},
+		{
+			name:  "self dependency",
+			procs: []string{"a"},
+			deps:  []dependency{{"a", "a"}},
+			err:   "Dependency cycle between processes: a -> a",
+		},
+		{
+			name:  "cycle",
+			procs: []string{"start", "a", "b", "c"},
+			deps:  []dependency{{"start", "a"}, {"a", "b"}, {"b", "c"}, {"c", "a"}},
+			err:   "Dependency cycle between processes: a -> b -> c -> a",
+		},
+	}
+
+	for _, tt := range tests {
+
+		pm := &ProcessManager{}
+		defs := map[string]*ProcessDefinition{}
+
+		for _, name := range tt.procs {
+			defs[name] = pm.Define(name, "/bin/true", nil)
+		}
+
+		for _, dep := range tt.deps {
+			defs[dep.from].DependsOn(dep.to, DependsStarted)
+		}
+
+		order, err := pm.startOrder()
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: startOrder returned %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: startOrder returned %s", tt.name, err)
+			continue
+		}
+
+		got := []string{}
+		for _, def := range order {
+			got = append(got, def.Name)
+		}
+
+		if !equalStrings(got, tt.want) {
+			t.Errorf("%s: startOrder = %v, want %v", tt.name, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestDefineReplaces(t *testing.T) {
+
+	pm := &ProcessManager{}
+
+	pm.Define("a", "/bin/false", nil)
+	pm.Define("b", "/bin/true", nil)
+	replaced := pm.Define("a", "/bin/true", nil, "--replaced")
+
+	order, err := pm.startOrder()
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(order) != 2 || order[0] != replaced || order[1].Name != "b" {
+		t.Errorf("startOrder after redefining a = %v", order)
+	}
+
+}
+
+func TestStartAllCopiesOptions(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/true")
+	}
+
+	pm := Processes.NewProcessManager(true, false, false)
+	shared := &ProcessManagerProcessOptions{}
+
+	pm.Define("a", "/bin/true", shared)
+	pm.Define("b", "/bin/true", shared)
+
+	if err := pm.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer pm.StopAll(syscall.SIGTERM)
+
+	a, b := pm.Process("a"), pm.Process("b")
+
+	if shared.OutputStdOut {
+		t.Error("StartAll changed the options of the definitions")
+	} else if a.options == shared || a.options == b.options {
+		t.Error("the processes share the options of their definitions")
+	} else if !a.options.OutputStdOut || !b.options.OutputStdOut {
+		t.Error("LogStdOut was not applied to the processes")
+	}
+
+}
@@ -0,0 +1,421 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// HealthProbe checks a supervised process in one of four ways: a TCP connect, an HTTP GET expecting a 2xx or 3xx
+// response, running a command expecting it to exit 0, or a pattern appearing in the STDOUT of the process since it
+// last started. Create one with TCPProbe, HTTPProbe, ExecProbe or StdoutProbe.
+type HealthProbe struct {
+	TCPAddress    string
+	HTTPURL       string
+	ExecPath      string
+	ExecArgs      []string
+	StdoutPattern *regexp.Regexp
+	//InitialDelay is waited after each start of the process before the first check
+	InitialDelay time.Duration
+	Interval     time.Duration
+	Timeout      time.Duration
+	//FailureThreshold consecutive failures fail the probe and SuccessThreshold consecutive successes pass it again
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+const (
+	defaultProbeInterval = 10 * time.Second
+	defaultProbeTimeout  = time.Second
+)
+
+func newHealthProbe() *HealthProbe {
+	return &HealthProbe{
+		Interval:         defaultProbeInterval,
+		Timeout:          defaultProbeTimeout,
+		FailureThreshold: 3,
+		SuccessThreshold: 1,
+	}
+}
+
+// TCPProbe passes when address (host:port) accepts a connection.
+func TCPProbe(address string) *HealthProbe {
+	hp := newHealthProbe()
+	hp.TCPAddress = address
+	return hp
+}
+
+// HTTPProbe passes when a GET of url responds with a 2xx or 3xx status, redirects are not followed.
+func HTTPProbe(url string) *HealthProbe {
+	hp := newHealthProbe()
+	hp.HTTPURL = url
+	return hp
+}
+
+// ExecProbe passes when the command exits 0 within the timeout, it is killed when it does not.
+func ExecProbe(path string, args ...string) *HealthProbe {
+	hp := newHealthProbe()
+	hp.ExecPath = path
+	hp.ExecArgs = args
+	return hp
+}
+
+// StdoutProbe passes once a line of STDOUT matching pattern has been written since the process last started, e.g.
+// "listening on". It is checked every 100ms unless Every is used and does not need OutputStdOut.
+func StdoutProbe(pattern string) *HealthProbe {
+	hp := newHealthProbe()
+	hp.StdoutPattern = regexp.MustCompile(pattern)
+	hp.Interval = 100 * time.Millisecond
+	return hp
+}
+
+func (hp *HealthProbe) Every(interval time.Duration) *HealthProbe {
+	hp.Interval = interval
+	return hp
+}
+
+func (hp *HealthProbe) WithTimeout(timeout time.Duration) *HealthProbe {
+	hp.Timeout = timeout
+	return hp
+}
+
+func (hp *HealthProbe) After(initialDelay time.Duration) *HealthProbe {
+	hp.InitialDelay = initialDelay
+	return hp
+}
+
+func (hp *HealthProbe) Thresholds(failures int, successes int) *HealthProbe {
+	hp.FailureThreshold = failures
+	hp.SuccessThreshold = successes
+	return hp
+}
+
+func (hp *HealthProbe) String() string {
+	switch {
+	case hp.TCPAddress != "":
+		return "tcp " + hp.TCPAddress
+	case hp.HTTPURL != "":
+		return "http " + hp.HTTPURL
+	case hp.ExecPath != "":
+		return "exec " + ShellJoin(append([]string{hp.ExecPath}, hp.ExecArgs...)...)
+	case hp.StdoutPattern != nil:
+		return "stdout " + hp.StdoutPattern.String()
+	default:
+		return "none"
+	}
+}
+
+func (hp *HealthProbe) check(pmp *ProcessManagerProcess) error {
+
+	timeout := hp.Timeout
+
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	switch {
+	case hp.TCPAddress != "":
+		conn, err := net.DialTimeout("tcp", hp.TCPAddress, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case hp.HTTPURL != "":
+		return checkHTTP(hp.HTTPURL, timeout)
+	case hp.ExecPath != "":
+		return checkExec(hp.ExecPath, hp.ExecArgs, timeout)
+	case hp.StdoutPattern != nil:
+		if !pmp.stdoutMatched(hp.StdoutPattern) {
+			return errors.New(fmt.Sprintf("STDOUT has not matched %s yet", hp.StdoutPattern.String()))
+		}
+		return nil
+	default:
+		return errors.New("Health probe has nothing to check")
+	}
+
+}
+
+func checkHTTP(url string, timeout time.Duration) error {
+
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(url)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return errors.New(fmt.Sprintf("GET %s responded %s", url, resp.Status))
+	}
+
+	return nil
+
+}
+
+func checkExec(path string, args []string, timeout time.Duration) error {
+
+	ec := Exec.CreateAsyncCommand(path, false, args...).CaptureStdoutAndStdErr(false, false)
+
+	if err := ec.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- ec.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if stderr := strings.TrimSpace(string(ec.GetStderrBuffer())); err != nil && stderr != "" {
+			return errors.New(fmt.Sprintf("%s: %s", err.Error(), stderr))
+		}
+		return err
+	case <-timer.C:
+		ec.Signal(os.Kill)
+		<-done
+		return errors.New(fmt.Sprintf("Health check timed out after %s", timeout))
+	}
+
+}
+
+// ProbeStatus is the state of one probe of a process, it is reset whenever the process starts.
+type ProbeStatus struct {
+	Probe   string
+	Passing bool
+	//ConsecutiveFailures and ConsecutiveSuccesses count towards the thresholds of the probe
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	Checks               int
+	LastCheck            time.Time
+	LastError            error
+}
+
+// HealthStatus is a snapshot of the health of a process. A process without probes is live and ready while it runs.
+type HealthStatus struct {
+	Running bool
+	//Live is false once the liveness probe has failed, the process is then killed so its restart policy applies
+	Live  bool
+	Ready bool
+	//Liveness and Readiness are nil when the process does not have that probe
+	Liveness  *ProbeStatus
+	Readiness *ProbeStatus
+}
+
+func (hs *HealthStatus) Healthy() bool {
+	return hs.Running && hs.Live && hs.Ready
+}
+
+// Health returns the current health of the process.
+func (pmp *ProcessManagerProcess) Health() *HealthStatus {
+
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+
+	status := &HealthStatus{
+		Running: pmp.running,
+		Live:    pmp.running,
+		Ready:   pmp.running,
+	}
+
+	if pmp.liveness != nil {
+		liveness := *pmp.liveness
+		status.Liveness = &liveness
+		status.Live = status.Live && liveness.Passing
+	}
+
+	if pmp.readiness != nil {
+		readiness := *pmp.readiness
+		status.Readiness = &readiness
+		status.Ready = status.Ready && readiness.Passing
+	}
+
+	return status
+
+}
+
+// startHealthChecks starts a goroutine for each probe of the process, they run until it exits for good
+func (pmp *ProcessManagerProcess) startHealthChecks() {
+
+	if pmp.options.Liveness != nil {
+		pmp.liveness = &ProbeStatus{
+			Probe:   pmp.options.Liveness.String(),
+			Passing: true,
+		}
+		go pmp.watchHealth(pmp.options.Liveness, pmp.liveness, true)
+	}
+
+	if pmp.options.Readiness != nil {
+		pmp.readiness = &ProbeStatus{
+			Probe: pmp.options.Readiness.String(),
+		}
+		go pmp.watchHealth(pmp.options.Readiness, pmp.readiness, false)
+	}
+
+}
+
+func (pmp *ProcessManagerProcess) watchHealth(probe *HealthProbe, status *ProbeStatus, liveness bool) {
+
+	kind := "readiness"
+
+	if liveness {
+		kind = "liveness"
+	}
+
+	run := -1
+
+	for {
+
+		pmp.lock.Lock()
+
+		delay := probe.Interval
+
+		if delay <= 0 {
+			delay = defaultProbeInterval
+		}
+
+		//a new run of the process starts over, live until proven otherwise but not ready until proven
+		if run != pmp.runs {
+			run = pmp.runs
+			*status = ProbeStatus{
+				Probe:   probe.String(),
+				Passing: liveness,
+			}
+			delay = probe.InitialDelay
+		}
+
+		pmp.lock.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-pmp.exited:
+			return
+		}
+
+		pmp.lock.Lock()
+		current := pmp.running && run == pmp.runs
+		pmp.lock.Unlock()
+
+		if !current {
+			continue
+		}
+
+		err := probe.check(pmp)
+
+		pmp.lock.Lock()
+
+		if run != pmp.runs {
+			pmp.lock.Unlock()
+			continue
+		}
+
+		wasPassing := status.Passing
+		status.Checks++
+		status.LastCheck = time.Now()
+		status.LastError = err
+
+		if err != nil {
+			status.ConsecutiveFailures++
+			status.ConsecutiveSuccesses = 0
+			if status.ConsecutiveFailures >= maxInt(probe.FailureThreshold, 1) {
+				status.Passing = false
+			}
+		} else {
+			status.ConsecutiveSuccesses++
+			status.ConsecutiveFailures = 0
+			if status.ConsecutiveSuccesses >= maxInt(probe.SuccessThreshold, 1) {
+				status.Passing = true
+			}
+		}
+
+		failed := wasPassing && !status.Passing
+
+		pmp.lock.Unlock()
+
+		if failed {
+			logWarn("Health check failed", LogF("command", pmp.execProc.path), LogF("probe", kind), LogF("check", probe.String()), LogF("error", err))
+		} else if !wasPassing && status.Passing {
+			logInfo("Health check passed", LogF("command", pmp.execProc.path), LogF("probe", kind), LogF("check", probe.String()))
+		}
+
+		if failed && liveness {
+			pmp.killUnhealthy(run)
+		}
+
+	}
+
+}
+
+// killUnhealthy kills a run of the process that failed its liveness probe, what happens next is up to its restart policy
+func (pmp *ProcessManagerProcess) killUnhealthy(run int) {
+
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+
+	if !pmp.running || run != pmp.runs {
+		return
+	}
+
+	logWarn("Killing unhealthy process", LogF("pid", pmp.execProc.Pid()), LogF("command", pmp.execProc.path))
+
+	if err := pmp.execProc.Signal(os.Kill); err != nil {
+		logError("Unable to kill unhealthy process", LogF("pid", pmp.execProc.Pid()), LogF("command", pmp.execProc.path), LogF("error", err))
+	}
+
+}
+
+// stdoutProbes returns the patterns the STDOUT of the process is matched against
+func (pmp *ProcessManagerProcess) stdoutProbes() []*regexp.Regexp {
+
+	patterns := []*regexp.Regexp{}
+
+	for _, probe := range []*HealthProbe{pmp.options.Liveness, pmp.options.Readiness} {
+		if probe != nil && probe.StdoutPattern != nil {
+			patterns = append(patterns, probe.StdoutPattern)
+		}
+	}
+
+	return patterns
+
+}
+
+// matchStdout is called with every line of STDOUT of the process
+func (pmp *ProcessManagerProcess) matchStdout(line string) {
+
+	for _, pattern := range pmp.stdoutProbes() {
+		if pattern.MatchString(line) {
+			pmp.lock.Lock()
+			pmp.stdoutMatches[pattern] = true
+			pmp.lock.Unlock()
+		}
+	}
+
+}
+
+func (pmp *ProcessManagerProcess) stdoutMatched(pattern *regexp.Regexp) bool {
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+	return pmp.stdoutMatches[pattern]
+}
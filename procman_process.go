@@ -5,16 +5,34 @@ package vutils
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
+	"regexp"
+	"sync"
+	"time"
 )
 
 type ProcessManagerProcess struct {
+	name     string
 	execProc *ExecAsyncCommand
 	pm       *ProcessManager
 	options  *ProcessManagerProcessOptions
-	waitChan chan bool
-	waitErr  error
+	lock     sync.Mutex
+	started  time.Time
+	running  bool
+	stopChan chan struct{}
+	stopOnce sync.Once
+	//restartTimes are the restarts within the window of the restart policy
+	restartTimes []time.Time
+	restarts     int
+	shortRuns    int
+	crashLoop    bool
+	//runs counts the starts of the process so health checks can tell a restart apart
+	runs          int
+	exited        chan struct{}
+	exitErr       error
+	liveness      *ProbeStatus
+	readiness     *ProbeStatus
+	stdoutMatches map[*regexp.Regexp]bool
 }
 
 type ProcessManagerProcessOptions struct {
@@ -26,71 +44,97 @@ type ProcessManagerProcessOptions struct {
 	OutputStdErr    bool
 	OnError         func(err error)
 	OnExit          func()
+	//Restart replaces the Restart policy of the ProcessManager for this process
+	Restart *RestartPolicy
+	//Liveness failing kills the process so Restart applies, Readiness only reports whether it is ready
+	Liveness  *HealthProbe
+	Readiness *HealthProbe
+	//Stop is how the process is stopped on shutdown, it replaces the StopOptions of the ProcessManager
+	Stop *StopOptions
 }
 
 func newProcManProcess(procMan *ProcessManager, binary string, options *ProcessManagerProcessOptions, cmdArgs ...string) *ProcessManagerProcess {
 
-	eproc := Exec.CreateAsyncCommand(binary, !options.OutputStdOut, cmdArgs...)
+	//STDOUT is read for probes matching it even when it is not output
+	stdoutProbe := options.Liveness != nil && options.Liveness.StdoutPattern != nil || options.Readiness != nil && options.Readiness.StdoutPattern != nil
 
-	if options.CWD != "" {
-
-		eproc.Proc.Dir = options.CWD
+	eproc := Exec.CreateAsyncCommand(binary, !options.OutputStdOut && !stdoutProbe, cmdArgs...)
 
+	pmp := &ProcessManagerProcess{
+		pm:            procMan,
+		execProc:      eproc,
+		options:       options,
+		stopChan:      make(chan struct{}),
+		exited:        make(chan struct{}),
+		stdoutMatches: map[*regexp.Regexp]bool{},
 	}
 
-	if options.ENV != nil && len(options.ENV) > 0 {
-
-		env := os.Environ()
+	if options.CWD != "" {
 
-		for key, val := range options.ENV {
+		eproc.SetWorkingDir(options.CWD)
 
-			env = append(env, fmt.Sprintf("%s=\"%s\"", key, val))
+	}
 
-		}
+	if options.ENV != nil && len(options.ENV) > 0 {
 
-		eproc.Proc.Env = env
+		eproc.UseEnv(InheritEnv().SetMap(options.ENV))
 
 	}
 
+	//the output is reattached whenever the process is restarted
 	if options.OutputStdErr {
-		errScanner := bufio.NewScanner(eproc.error)
-		go func() {
-			for errScanner.Scan() {
-				txt := errScanner.Text()
-				if options.ParseStdErrLine != nil {
-					options.ParseStdErrLine(txt)
+		eproc.attachOutput(func() {
+			errScanner := bufio.NewScanner(eproc.stderrSource())
+			eproc.ioWait.Add(1)
+			go func() {
+				defer eproc.ioWait.Done()
+				for errScanner.Scan() {
+					txt := errScanner.Text()
+					if options.ParseStdErrLine != nil {
+						options.ParseStdErrLine(txt)
+					}
+					fmt.Fprintln(os.Stderr, txt)
 				}
-				log.Print(txt)
-			}
-		}()
+			}()
+		})
 	}
 
-	if options.OutputStdOut {
-		scanner := bufio.NewScanner(eproc.reader)
-		go func() {
-			for scanner.Scan() {
-				txt := scanner.Text()
-				if options.ParseStdOutLine != nil {
-					options.ParseStdOutLine(txt)
+	if (options.OutputStdOut || len(pmp.stdoutProbes()) > 0) && !eproc.errOnly {
+		eproc.attachOutput(func() {
+			scanner := bufio.NewScanner(eproc.stdoutSource())
+			eproc.ioWait.Add(1)
+			go func() {
+				defer eproc.ioWait.Done()
+				for scanner.Scan() {
+					txt := scanner.Text()
+					pmp.matchStdout(txt)
+					if !options.OutputStdOut {
+						continue
+					}
+					if options.ParseStdOutLine != nil {
+						options.ParseStdOutLine(txt)
+					}
+					fmt.Fprintln(os.Stdout, txt)
 				}
-				log.Print(txt)
-			}
-		}()
+			}()
+		})
 	}
 
-	pmp := ProcessManagerProcess{
-		pm:       procMan,
-		execProc: eproc,
-		options:  options,
-		waitChan: make(chan bool),
-	}
-
-	return &pmp
+	return pmp
 
 }
 
 func newProcManProcessFromExec(procMan *ProcessManager, options *ProcessManagerProcessOptions, eproc *ExecAsyncCommand) *ProcessManagerProcess {
 
+	pmp := &ProcessManagerProcess{
+		pm:            procMan,
+		execProc:      eproc,
+		options:       options,
+		stopChan:      make(chan struct{}),
+		exited:        make(chan struct{}),
+		stdoutMatches: map[*regexp.Regexp]bool{},
+	}
+
 	if options.CWD != "" {
 
 		eproc.SetWorkingDir(options.CWD)
@@ -99,74 +143,78 @@ func newProcManProcessFromExec(procMan *ProcessManager, options *ProcessManagerP
 
 	if options.ENV != nil && len(options.ENV) > 0 {
 
-		env := os.Environ()
-
-		for key, val := range options.ENV {
-
-			env = append(env, fmt.Sprintf("%s=\"%s\"", key, val))
-
-		}
-
-		eproc.SetEnv(env)
+		eproc.UseEnv(InheritEnv().SetMap(options.ENV))
 
 	}
 
+	//the output is reattached whenever the process is restarted
 	if options.OutputStdErr {
-		errScanner := bufio.NewScanner(eproc.error)
-		go func() {
-			for errScanner.Scan() {
-				txt := errScanner.Text()
-				if options.ParseStdErrLine != nil {
-					options.ParseStdErrLine(txt)
+		eproc.attachOutput(func() {
+			errScanner := bufio.NewScanner(eproc.stderrSource())
+			eproc.ioWait.Add(1)
+			go func() {
+				defer eproc.ioWait.Done()
+				for errScanner.Scan() {
+					txt := errScanner.Text()
+					if options.ParseStdErrLine != nil {
+						options.ParseStdErrLine(txt)
+					}
+					fmt.Fprintln(os.Stderr, txt)
 				}
-				fmt.Println(txt)
-			}
-		}()
+			}()
+		})
 	}
 
-	if options.OutputStdOut {
-		scanner := bufio.NewScanner(eproc.reader)
-		go func() {
-			for scanner.Scan() {
-				txt := scanner.Text()
-				if options.ParseStdOutLine != nil {
-					options.ParseStdOutLine(txt)
+	if (options.OutputStdOut || len(pmp.stdoutProbes()) > 0) && !eproc.errOnly {
+		eproc.attachOutput(func() {
+			scanner := bufio.NewScanner(eproc.stdoutSource())
+			eproc.ioWait.Add(1)
+			go func() {
+				defer eproc.ioWait.Done()
+				for scanner.Scan() {
+					txt := scanner.Text()
+					pmp.matchStdout(txt)
+					if !options.OutputStdOut {
+						continue
+					}
+					if options.ParseStdOutLine != nil {
+						options.ParseStdOutLine(txt)
+					}
+					fmt.Fprintln(os.Stdout, txt)
 				}
-				fmt.Println(txt)
-			}
-		}()
+			}()
+		})
 	}
 
-	pmp := ProcessManagerProcess{
-		pm:       procMan,
-		execProc: eproc,
-		options:  options,
-		waitChan: make(chan bool),
-	}
-
-	return &pmp
+	return pmp
 
 }
 
 func (pmp *ProcessManagerProcess) Signal(signal os.Signal) error {
 
-	log.Printf("Signalling process with %d", signal)
+	logDebug("Signalling process", LogF("pid", pmp.Pid()), LogF("command", pmp.execProc.path), LogF("signal", signal))
 
-	pmp.waitChan <- true
+	//the command is swapped for a new one when the process is restarted
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
 
-	return pmp.execProc.Proc.Process.Signal(signal)
+	return pmp.execProc.Signal(signal)
 
 }
 
+// Wait returns once the process has exited for good, after any restarts, any number of callers can wait.
 func (pmp *ProcessManagerProcess) Wait() error {
 
-	waitRes := <-pmp.waitChan
-	if !waitRes {
-		return pmp.waitErr
-	}
+	<-pmp.exited
 
-	return nil
+	return pmp.exitError()
+
+}
 
+func (pmp *ProcessManagerProcess) exitError() error {
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+	return pmp.exitErr
 }
 
 func (pmp *ProcessManagerProcess) Start() error {
@@ -177,24 +225,72 @@ func (pmp *ProcessManagerProcess) Start() error {
 		return err
 	}
 
-	go func() {
+	pmp.lock.Lock()
+	pmp.started = time.Now()
+	pmp.running = true
+	pmp.runs++
+	pmp.lock.Unlock()
 
-		err := pmp.execProc.Proc.Wait()
-		fmt.Println("Ending Run Proc")
-		defer close(pmp.waitChan)
-		if err != nil {
-			pmp.waitErr = err
-			pmp.waitChan <- false
-			return
+	pmp.startHealthChecks()
+
+	go pmp.supervise()
+
+	return nil
+
+}
+
+// Pid returns the pid of the current run of the process.
+func (pmp *ProcessManagerProcess) Pid() int {
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+	return pmp.execProc.Pid()
+}
+
+// supervise waits for the process to exit, restarting it for as long as its restart policy says so
+func (pmp *ProcessManagerProcess) supervise() {
+
+	err := pmp.execProc.Wait()
+
+	for {
+
+		pmp.lock.Lock()
+		pmp.running = false
+		pmp.lock.Unlock()
+
+		logDebug("Process exited", LogF("pid", pmp.execProc.Pid()), LogF("command", pmp.execProc.path), LogF("exitCode", pmp.execProc.ExitCode()))
+
+		restart, delay := pmp.shouldRestart(err)
+
+		if !restart {
+			break
 		}
-		pmp.waitChan <- true
-		pmp.pm.removeProcessFromMap(pmp)
-		if pmp.options.OnExit != nil {
-			pmp.options.OnExit()
+
+		restarted, startErr := pmp.restart(delay)
+
+		if !restarted {
+			break
+		} else if startErr != nil {
+			//a restart that fails to start counts as another failure
+			err = startErr
+			continue
 		}
 
-	}()
+		err = pmp.execProc.Wait()
 
-	return nil
+	}
+
+	pmp.lock.Lock()
+	pmp.exitErr = err
+	pmp.lock.Unlock()
+
+	close(pmp.exited)
+
+	if err != nil {
+		return
+	}
+	pmp.pm.removeProcessFromMap(pmp)
+	if pmp.options.OnExit != nil {
+		pmp.options.OnExit()
+	}
 
 }
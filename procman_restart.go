@@ -0,0 +1,219 @@
+// +build !js
+
+package vutils
+
+import (
+	"os"
+	"regexp"
+	"time"
+)
+
+type RestartMode int
+
+const (
+	//RestartNever leaves a process that has exited alone, this is the default
+	RestartNever RestartMode = iota
+	//RestartOnFailure restarts a process that exited with a non-zero code or was killed by a signal
+	RestartOnFailure
+	//RestartAlways restarts a process whenever it exits, unless it was stopped
+	RestartAlways
+)
+
+func (rm RestartMode) String() string {
+	switch rm {
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return "never"
+	}
+}
+
+// RestartPolicy decides whether the ProcessManager restarts a process once it exits. The delay before a restart
+// starts at Backoff and doubles every time the process exits again within MinUptime of starting, up to MaxBackoff.
+//
+// A process restarted more than MaxRestarts times within Window is crash looping, it is not restarted again and
+// OnCrashLoop is called.
+type RestartPolicy struct {
+	Mode       RestartMode
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	//MinUptime is how long a process has to run for its next restart to start from Backoff again
+	MinUptime time.Duration
+	//MaxRestarts of 0 restarts a process forever, with no Window every restart counts towards it
+	MaxRestarts int
+	Window      time.Duration
+	//OnRestart is called before waiting out the delay with the number of the restart and why the process exited
+	OnRestart   func(proc *ProcessManagerProcess, restart int, err error)
+	OnCrashLoop func(proc *ProcessManagerProcess, err error)
+}
+
+// NewRestartPolicy returns a policy backing off from one second up to one minute that gives up on a process that is
+// restarted five times within a minute.
+func NewRestartPolicy(mode RestartMode) *RestartPolicy {
+	return &RestartPolicy{
+		Mode:        mode,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		MinUptime:   10 * time.Second,
+		MaxRestarts: 5,
+		Window:      time.Minute,
+	}
+}
+
+// delay returns the backoff before the restart following the given number of consecutive short runs
+func (rp *RestartPolicy) delay(shortRuns int) time.Duration {
+
+	delay := rp.Backoff
+
+	for i := 1; i < shortRuns && (rp.MaxBackoff <= 0 || delay < rp.MaxBackoff); i++ {
+		delay *= 2
+	}
+
+	if rp.MaxBackoff > 0 && delay > rp.MaxBackoff {
+		delay = rp.MaxBackoff
+	}
+
+	return delay
+
+}
+
+// Restarts returns how many times the process has been restarted.
+func (pmp *ProcessManagerProcess) Restarts() int {
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+	return pmp.restarts
+}
+
+// CrashLooping reports whether the process was given up on for being restarted too often.
+func (pmp *ProcessManagerProcess) CrashLooping() bool {
+	pmp.lock.Lock()
+	defer pmp.lock.Unlock()
+	return pmp.crashLoop
+}
+
+// Stop signals the process and makes sure it is not restarted, a process waiting to be restarted is not started again.
+func (pmp *ProcessManagerProcess) Stop(signal os.Signal) error {
+
+	pmp.lock.Lock()
+	running := pmp.running
+	pmp.stopOnce.Do(func() {
+		close(pmp.stopChan)
+	})
+	pmp.lock.Unlock()
+
+	if !running {
+		return nil
+	}
+
+	return pmp.Signal(signal)
+
+}
+
+// shouldRestart records the exit of the process and reports whether it should be restarted and after how long
+func (pmp *ProcessManagerProcess) shouldRestart(err error) (bool, time.Duration) {
+
+	policy := pmp.restartPolicy()
+
+	if policy == nil || policy.Mode == RestartNever || pmp.execProc.IsDryRun() || pmp.isStopped() {
+		return false, 0
+	} else if policy.Mode == RestartOnFailure && err == nil {
+		return false, 0
+	}
+
+	pmp.lock.Lock()
+
+	now := time.Now()
+
+	if now.Sub(pmp.started) >= policy.MinUptime {
+		pmp.shortRuns = 0
+	}
+
+	if policy.Window > 0 {
+		recent := pmp.restartTimes[:0]
+		for _, t := range pmp.restartTimes {
+			if now.Sub(t) < policy.Window {
+				recent = append(recent, t)
+			}
+		}
+		pmp.restartTimes = recent
+	}
+
+	if policy.MaxRestarts > 0 && len(pmp.restartTimes) >= policy.MaxRestarts {
+		pmp.crashLoop = true
+		pmp.lock.Unlock()
+		logError("Process is crash looping, giving up", LogF("command", pmp.execProc.path), LogF("restarts", len(pmp.restartTimes)), LogF("window", policy.Window), LogF("error", err))
+		if policy.OnCrashLoop != nil {
+			policy.OnCrashLoop(pmp, err)
+		}
+		return false, 0
+	}
+
+	pmp.restartTimes = append(pmp.restartTimes, now)
+	pmp.restarts++
+	pmp.shortRuns++
+	restart, delay := pmp.restarts, policy.delay(pmp.shortRuns)
+
+	pmp.lock.Unlock()
+
+	logWarn("Process exited, restarting", LogF("command", pmp.execProc.path), LogF("policy", policy.Mode.String()), LogF("restart", restart), LogF("delay", delay), LogF("error", err))
+
+	if policy.OnRestart != nil {
+		policy.OnRestart(pmp, restart, err)
+	}
+
+	return true, delay
+
+}
+
+func (pmp *ProcessManagerProcess) restartPolicy() *RestartPolicy {
+	if pmp.options.Restart != nil {
+		return pmp.options.Restart
+	}
+	return pmp.pm.Restart
+}
+
+func (pmp *ProcessManagerProcess) isStopped() bool {
+	select {
+	case <-pmp.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// restart waits out delay and starts the process again, reporting false when it was stopped in the meantime
+func (pmp *ProcessManagerProcess) restart(delay time.Duration) (bool, error) {
+
+	select {
+	case <-time.After(delay):
+	case <-pmp.stopChan:
+		return false, nil
+	}
+
+	//every run gets the full number of retries of the command's own retry policy
+	pmp.execProc.attempts = nil
+
+	logInfo("Restarting process", LogF("command", pmp.execProc.path), LogF("restart", pmp.Restarts()))
+
+	pmp.lock.Lock()
+	pmp.stdoutMatches = map[*regexp.Regexp]bool{}
+	err := pmp.execProc.startAgain()
+	if err == nil {
+		pmp.started = time.Now()
+		pmp.running = true
+		pmp.runs++
+	}
+	pmp.lock.Unlock()
+
+	if err != nil {
+		logError("Unable to restart process", LogF("command", pmp.execProc.path), LogF("error", err))
+		return true, err
+	}
+
+	pmp.pm.updateProcessPid(pmp)
+
+	return true, nil
+
+}
@@ -0,0 +1,162 @@
+// +build !js
+
+package vutils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyDelay(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		policy    *RestartPolicy
+		shortRuns int
+		want      time.Duration
+	}{
+		{"first restart", &RestartPolicy{Backoff: time.Second, MaxBackoff: time.Minute}, 1, time.Second},
+		{"no short runs", &RestartPolicy{Backoff: time.Second, MaxBackoff: time.Minute}, 0, time.Second},
+		{"doubles", &RestartPolicy{Backoff: time.Second, MaxBackoff: time.Minute}, 4, 8 * time.Second},
+		{"capped", &RestartPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}, 5, 10 * time.Second},
+		{"capped for good", &RestartPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}, 1000, 10 * time.Second},
+		{"backoff above the cap", &RestartPolicy{Backoff: time.Minute, MaxBackoff: time.Second}, 1, time.Second},
+		{"uncapped", &RestartPolicy{Backoff: 100 * time.Millisecond}, 6, 3200 * time.Millisecond},
+		{"no backoff", &RestartPolicy{MaxBackoff: time.Minute}, 3, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.delay(tt.shortRuns); got != tt.want {
+			t.Errorf("%s: delay(%d) = %s, want %s", tt.name, tt.shortRuns, got, tt.want)
+		}
+	}
+
+}
+
+// newRestartTestProcess returns a process that has just been started, as far as shouldRestart is concerned
+func newRestartTestProcess(policy *RestartPolicy) *ProcessManagerProcess {
+	return &ProcessManagerProcess{
+		execProc: Exec.CreateAsyncCommand("worker", false),
+		pm:       &ProcessManager{},
+		options: &ProcessManagerProcessOptions{
+			Restart: policy,
+		},
+		started:  time.Now(),
+		stopChan: make(chan struct{}),
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+
+	failed := errors.New("exit status 1")
+
+	tests := []struct {
+		name   string
+		policy *RestartPolicy
+		err    error
+		want   bool
+	}{
+		{"no policy", nil, failed, false},
+		{"never", &RestartPolicy{Mode: RestartNever}, failed, false},
+		{"on failure after a failure", &RestartPolicy{Mode: RestartOnFailure}, failed, true},
+		{"on failure after success", &RestartPolicy{Mode: RestartOnFailure}, nil, false},
+		{"always after success", &RestartPolicy{Mode: RestartAlways}, nil, true},
+	}
+
+	for _, tt := range tests {
+		if got, _ := newRestartTestProcess(tt.policy).shouldRestart(tt.err); got != tt.want {
+			t.Errorf("%s: shouldRestart = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	pmp := newRestartTestProcess(&RestartPolicy{Mode: RestartAlways})
+	pmp.Stop(nil)
+
+	if restart, _ := pmp.shouldRestart(nil); restart {
+		t.Error("shouldRestart restarted a stopped process")
+	}
+
+}
+
+func TestShouldRestartBackoff(t *testing.T) {
+
+	policy := &RestartPolicy{
+		Mode:       RestartAlways,
+		Backoff:    time.Second,
+		MaxBackoff: 4 * time.Second,
+		MinUptime:  time.Minute,
+	}
+
+	pmp := newRestartTestProcess(policy)
+
+	//every run is shorter than MinUptime so the delay keeps doubling
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		restart, delay := pmp.shouldRestart(nil)
+		if !restart || delay != want {
+			t.Fatalf("restart %d: shouldRestart = %t %s, want true %s", i+1, restart, delay, want)
+		}
+	}
+
+	//a run that lasted MinUptime starts over from Backoff
+	pmp.started = time.Now().Add(-2 * time.Minute)
+
+	if _, delay := pmp.shouldRestart(nil); delay != time.Second {
+		t.Errorf("delay after a long run = %s, want %s", delay, time.Second)
+	}
+
+	if pmp.Restarts() != 5 {
+		t.Errorf("Restarts = %d, want 5", pmp.Restarts())
+	}
+
+}
+
+func TestShouldRestartCrashLoop(t *testing.T) {
+
+	tests := []struct {
+		name string
+		//ages of the earlier restarts
+		restarts []time.Duration
+		want     bool
+	}{
+		{"below the limit", []time.Duration{time.Second, 2 * time.Second}, true},
+		{"at the limit", []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, false},
+		{"restarts outside the window", []time.Duration{time.Second, 2 * time.Minute, 3 * time.Minute}, true},
+	}
+
+	for _, tt := range tests {
+
+		loops := 0
+
+		pmp := newRestartTestProcess(&RestartPolicy{
+			Mode:        RestartOnFailure,
+			MaxRestarts: 3,
+			Window:      time.Minute,
+			OnCrashLoop: func(proc *ProcessManagerProcess, err error) {
+				loops++
+			},
+		})
+
+		for _, age := range tt.restarts {
+			pmp.restartTimes = append(pmp.restartTimes, time.Now().Add(-age))
+		}
+
+		restart, _ := pmp.shouldRestart(errors.New("exit status 1"))
+
+		if restart != tt.want {
+			t.Errorf("%s: shouldRestart = %t, want %t", tt.name, restart, tt.want)
+		} else if pmp.CrashLooping() != !tt.want || loops != map[bool]int{true: 0, false: 1}[tt.want] {
+			t.Errorf("%s: CrashLooping = %t with %d calls of OnCrashLoop", tt.name, pmp.CrashLooping(), loops)
+		}
+
+	}
+
+	//without a window every restart counts
+	pmp := newRestartTestProcess(&RestartPolicy{Mode: RestartAlways, MaxRestarts: 2})
+	pmp.restartTimes = []time.Time{time.Now().Add(-time.Hour), time.Now().Add(-2 * time.Hour)}
+
+	if restart, _ := pmp.shouldRestart(nil); restart {
+		t.Error("shouldRestart restarted a process beyond MaxRestarts with no window")
+	}
+
+}
@@ -0,0 +1,330 @@
+// +build !js
+
+package vutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// shutdownKillWait is how long a process killed because the shutdown deadline passed is waited for
+const shutdownKillWait = time.Second
+
+// ProcessStopResult is how a process was stopped.
+type ProcessStopResult struct {
+	//Name is set for processes started by StartAll
+	Name    string
+	Pid     int
+	Command string
+	//Signals that were sent, in order
+	Signals []os.Signal
+	//Escalated is set when the first signal did not stop the process in time
+	Escalated bool
+	Killed    bool
+	//DeadlineExceeded is set when the shutdown deadline cut the stop sequence short
+	DeadlineExceeded bool
+	Stopped          bool
+	Duration         time.Duration
+	Err              error
+}
+
+func (psr *ProcessStopResult) String() string {
+
+	name := psr.Command
+
+	if psr.Name != "" {
+		name = psr.Name
+	}
+
+	signals := []string{}
+
+	for _, sig := range psr.Signals {
+		signals = append(signals, sig.String())
+	}
+
+	msg := fmt.Sprintf("%s (pid %d) stopped in %s", name, psr.Pid, psr.Duration.Round(time.Millisecond))
+
+	if !psr.Stopped {
+		msg = fmt.Sprintf("%s (pid %d) is still running after %s", name, psr.Pid, psr.Duration.Round(time.Millisecond))
+	}
+
+	if len(signals) > 0 {
+		msg += " after " + strings.Join(signals, ", ")
+	}
+
+	if psr.DeadlineExceeded {
+		msg += " (shutdown deadline exceeded)"
+	}
+
+	return msg
+
+}
+
+// ShutdownReport lists how every process was stopped by Shutdown.
+type ShutdownReport struct {
+	Started   time.Time
+	Duration  time.Duration
+	Processes []*ProcessStopResult
+	lock      sync.Mutex
+}
+
+func (sr *ShutdownReport) add(result *ProcessStopResult) {
+	sr.lock.Lock()
+	sr.Processes = append(sr.Processes, result)
+	sr.lock.Unlock()
+}
+
+// Escalated returns the processes that needed more than their first signal to stop.
+func (sr *ShutdownReport) Escalated() []*ProcessStopResult {
+	escalated := []*ProcessStopResult{}
+	for _, result := range sr.Processes {
+		if result.Escalated {
+			escalated = append(escalated, result)
+		}
+	}
+	return escalated
+}
+
+// Err reports the processes that could not be stopped.
+func (sr *ShutdownReport) Err() error {
+
+	failed := []string{}
+
+	for _, result := range sr.Processes {
+		if result.Err != nil {
+			failed = append(failed, result.Err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("Unable to stop all processes: %s", strings.Join(failed, ", ")))
+	}
+
+	return nil
+
+}
+
+func (sr *ShutdownReport) String() string {
+	lines := []string{}
+	for _, result := range sr.Processes {
+		lines = append(lines, result.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stopOptions returns the stop sequence of the process, the ProcessManager has always sent SIGINT first
+func (pmp *ProcessManagerProcess) stopOptions() *StopOptions {
+	if pmp.options.Stop != nil {
+		return pmp.options.Stop
+	} else if pmp.pm.StopOptions != nil {
+		return pmp.pm.StopOptions
+	}
+	return &StopOptions{
+		Signals: []os.Signal{syscall.SIGINT},
+	}
+}
+
+// StopGracefully stops the process for good with its stop sequence (see ProcessManagerProcessOptions.Stop), sending
+// each signal in turn until it exits.
+func (pmp *ProcessManagerProcess) StopGracefully() *ProcessStopResult {
+	return pmp.stopGracefully(pmp.stopOptions(), time.Time{})
+}
+
+// stopGracefully runs the stop sequence of options, when deadline (if set) passes it skips straight to SIGKILL
+func (pmp *ProcessManagerProcess) stopGracefully(options *StopOptions, deadline time.Time) *ProcessStopResult {
+
+	start := time.Now()
+
+	pmp.lock.Lock()
+	result := &ProcessStopResult{
+		Name:    pmp.name,
+		Pid:     pmp.execProc.Pid(),
+		Command: pmp.execProc.path,
+	}
+	running := pmp.running
+	pmp.stopOnce.Do(func() {
+		close(pmp.stopChan)
+	})
+	pmp.lock.Unlock()
+
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	//a process waiting to be restarted exits as soon as it sees it was stopped
+	if !running {
+		<-pmp.exited
+		result.Stopped = true
+		return result
+	}
+
+	signals, timeouts := options.steps(syscall.SIGINT)
+
+	for i, sig := range signals {
+
+		timeout := timeouts[i]
+
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 && sig != os.Kill && signals[len(signals)-1] == os.Kill {
+				result.DeadlineExceeded = true
+				continue
+			} else if remaining <= 0 {
+				result.DeadlineExceeded = true
+				timeout = shutdownKillWait
+			} else if remaining < timeout {
+				timeout = remaining
+			}
+		}
+
+		if i > 0 {
+			result.Escalated = true
+			logWarn("Process did not stop, escalating", LogF("pid", result.Pid), LogF("command", result.Command), LogF("signal", sig.String()))
+		}
+
+		result.Signals = append(result.Signals, sig)
+		result.Killed = sig == os.Kill
+
+		pmp.lock.Lock()
+		err := pmp.execProc.Signal(sig)
+		pmp.lock.Unlock()
+
+		if err != nil {
+			//the process may have exited just before the signal was sent
+			select {
+			case <-pmp.exited:
+				result.Stopped = true
+				return result
+			default:
+				logWarn("Unable to signal process", LogF("pid", result.Pid), LogF("command", result.Command), LogF("signal", sig.String()), LogF("error", err))
+			}
+		}
+
+		timer := time.NewTimer(timeout)
+
+		select {
+		case <-pmp.exited:
+			timer.Stop()
+			result.Stopped = true
+			return result
+		case <-timer.C:
+		}
+
+	}
+
+	result.Err = errors.New(fmt.Sprintf("%s (pid %d) did not stop after %s", result.Command, result.Pid, result.Signals[len(result.Signals)-1].String()))
+
+	return result
+
+}
+
+// Shutdown stops every process for good. Processes started by StartAll are stopped first in reverse dependency order,
+// then all others at once, each with its stop sequence. With ShutdownTimeout set every stop sequence is cut short by
+// SIGKILL once it has passed.
+func (pm *ProcessManager) Shutdown() *ShutdownReport {
+
+	report := &ShutdownReport{
+		Started:   time.Now(),
+		Processes: []*ProcessStopResult{},
+	}
+
+	var deadline time.Time
+
+	if pm.ShutdownTimeout > 0 {
+		deadline = report.Started.Add(pm.ShutdownTimeout)
+	}
+
+	pm.stopNamed(nil, deadline, report)
+
+	g, _ := errgroup.WithContext(context.Background())
+
+	for _, proc := range pm.processes() {
+
+		if proc.isStopped() {
+			continue
+		}
+
+		proc := proc
+
+		g.Go(func() error {
+			report.add(proc.stopGracefully(proc.stopOptions(), deadline))
+			return nil
+		})
+
+	}
+
+	g.Wait()
+
+	report.Duration = time.Since(report.Started)
+
+	for _, result := range report.Escalated() {
+		logWarn("Process needed escalation to stop", LogF("pid", result.Pid), LogF("command", result.Command), LogF("signals", len(result.Signals)), LogF("killed", result.Killed))
+	}
+
+	logInfo("Shut down processes", LogF("count", len(report.Processes)), LogF("escalated", len(report.Escalated())), LogF("duration", report.Duration))
+
+	return report
+
+}
+
+// stopNamed stops the processes started by StartAll in reverse order, first sending signal instead of the first
+// signal of their stop sequence when it is set
+func (pm *ProcessManager) stopNamed(signal os.Signal, deadline time.Time, report *ShutdownReport) {
+
+	pm.lock.Lock()
+	names := append([]string{}, pm.started...)
+	pm.lock.Unlock()
+
+	for i := len(names) - 1; i >= 0; i-- {
+
+		proc := pm.Process(names[i])
+
+		if proc == nil {
+			continue
+		}
+
+		options := proc.stopOptions()
+
+		if signal != nil {
+			signals := append([]os.Signal{signal}, options.Signals[minInt(1, len(options.Signals)):]...)
+			options = &StopOptions{
+				Signals:  signals,
+				Timeout:  options.Timeout,
+				Timeouts: options.Timeouts,
+				NoKill:   options.NoKill,
+			}
+		}
+
+		logInfo("Stopping process", LogF("name", names[i]), LogF("pid", proc.Pid()))
+
+		result := proc.stopGracefully(options, deadline)
+		report.add(result)
+
+		if result.Stopped {
+			pm.lock.Lock()
+			delete(pm.named, names[i])
+			pm.lock.Unlock()
+		}
+
+	}
+
+	//processes that could not be stopped are kept so they can be stopped again
+	pm.lock.Lock()
+	remaining := []string{}
+	for _, name := range pm.started {
+		if _, ok := pm.named[name]; ok {
+			remaining = append(remaining, name)
+		}
+	}
+	pm.started = remaining
+	pm.lock.Unlock()
+
+}